@@ -0,0 +1,78 @@
+package apperror
+
+import (
+	"errors"
+	"maps"
+	"time"
+)
+
+// Kind classifies an application error. Every kind maps to exactly one HTTP
+// status in errorfmt.Write, handlers never pick status codes themselves.
+type Kind string
+
+const (
+	Validation   Kind = "validation"
+	Unauthorized Kind = "unauthorized"
+	NotFound     Kind = "not_found"
+	Conflict     Kind = "conflict"
+	RateLimited  Kind = "rate_limited"
+	Upstream     Kind = "upstream_unavailable"
+	Internal     Kind = "internal"
+)
+
+type Error struct {
+	Kind       Kind
+	Code       string // machine-readable, stable across message changes
+	Message    string
+	RetryAfter time.Duration  // RateLimited only
+	Extensions map[string]any // extra members of the problem body
+	Err        error          // cause, logged but never sent to clients
+}
+
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes copies of a sentinel, e.g. with RetryAfter filled in, match the
+// sentinel itself.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Code == e.Code
+}
+
+// WithRetryAfter returns a copy of e carrying the delay for Retry-After.
+func (e *Error) WithRetryAfter(d time.Duration) *Error {
+	c := *e
+	c.RetryAfter = d
+	return &c
+}
+
+// WithExtension returns a copy of e with an extra problem member, e.g. the
+// candidates of an ambiguous lookup.
+func (e *Error) WithExtension(key string, value any) *Error {
+	c := *e
+	c.Extensions = maps.Clone(e.Extensions)
+	if c.Extensions == nil {
+		c.Extensions = make(map[string]any)
+	}
+	c.Extensions[key] = value
+	return &c
+}
+
+// Classify returns err as an application error. Unclassified errors become
+// Internal so their text never leaks to clients.
+func Classify(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return &Error{Kind: Internal, Code: "internal", Message: "Internal server error.", Err: err}
+}
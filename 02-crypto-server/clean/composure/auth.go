@@ -2,15 +2,42 @@ package composure
 
 import (
 	"cryptoserver/clean/usecase"
+	"cryptoserver/clean/domain"
 	"cryptoserver/repository"
 	"cryptoserver/security"
 	"cryptoserver/clean/controller"
+	"cryptoserver/config"
+	"fmt"
+	"io"
+
+	"github.com/redis/go-redis/v9"
 )
 
-func NewAuth() *controller.Auth {
-	repo := repository.NewRai()
+func newUserRepository(cfg config.Storage) (domain.UserRepository, error) {
+	switch cfg.Backend {
+	case "sqlite":
+		return repository.NewSQLite(cfg.SQLitePath)
+	case "memory":
+		return repository.NewRai(), nil
+	default:
+		return nil, fmt.Errorf("unknown user storage %q", cfg.Backend)
+	}
+}
+
+// NewAuth also returns a function that releases the user storage.
+func NewAuth(cfg *config.Config, cache *redis.Client) (*controller.Auth, func() error, error) {
+	repo, err := newUserRepository(cfg.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeRepo := func() error { return nil }
+	if closer, ok := repo.(io.Closer); ok {
+		closeRepo = closer.Close
+	}
+
 	hasher := security.NewHasher()
+	tokens := usecase.NewToken(repository.NewRedisTokens(cache), cfg.Auth.RefreshTTL)
 	usecase := usecase.NewAuth(repo, hasher)
-	auth := controller.NewAuth(usecase)
-	return auth
+	auth := controller.NewAuth(usecase, tokens, cfg.Auth)
+	return auth, closeRepo, nil
 }
@@ -1,19 +1,32 @@
 package controller
 
 import (
+	"context"
 	"time"
 	"fmt"
-	"errors"
+	"strings"
 	"net/http"
+	"log/slog"
 	"encoding/json"
+	"errors"
+	"io"
+	"cryptoserver/clean/domain"
 	"cryptoserver/clean/usecase"
+	"cryptoserver/config"
+	"cryptoserver/apperror"
+	"cryptoserver/errorfmt"
+	"cryptoserver/metrics"
+	"cryptoserver/security"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 var (
-	ErrInvalidJson  = errors.New("Invalid json.")
-	ErrInvalidDTO   = errors.New("Username and password required.")
+	ErrInvalidJson  = apperror.New(apperror.Validation, "invalid_json", "Invalid json.")
+	ErrInvalidDTO   = apperror.New(apperror.Validation, "missing_credentials", "Username and password required.")
+	ErrNoRefresh    = apperror.New(apperror.Validation, "missing_refresh_token", "Refresh token required.")
+	ErrInvalidToken = apperror.New(apperror.Unauthorized, "invalid_token", "Invalid token.")
+	ErrNoToken      = apperror.New(apperror.Unauthorized, "missing_token", "You are not authorized.")
 )
 
 type userDTO struct { // DATA TRANSFER OBJECT
@@ -21,40 +34,44 @@ type userDTO struct { // DATA TRANSFER OBJECT
 	Password string `json:"password"`
 }
 
-type Auth struct {
-	ua *usecase.Auth
+type refreshDTO struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
-func NewAuth(ua *usecase.Auth) *Auth {
-	return &Auth{ua: ua}
+type accountDTO struct {
+	Currency string `json:"currency"`
 }
 
-type tokenJson struct {
-	Token string `json:"token"`
+type accountJson struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Currency string `json:"currency"`
 }
 
-func newTokenJson(token string) tokenJson {
-	return tokenJson{Token: token}
+type Auth struct {
+	ua     *usecase.Auth
+	ut     *usecase.Token
+	secret []byte
+	ttl    time.Duration
 }
 
-func formateToken(token string) string {
-	tokenStruct := newTokenJson(token)
-	tokenJson, _ := json.Marshal(tokenStruct)
-	return string(tokenJson)
+func NewAuth(ua *usecase.Auth, ut *usecase.Token, cfg config.Auth) *Auth {
+	return &Auth{ua: ua, ut: ut, secret: []byte(cfg.JWTSecret), ttl: cfg.TokenTTL}
 }
 
-type errorJson struct {
-	Err string `json:"error"`
+type tokenJson struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
-func newErrorJson(err string) errorJson {
-	return errorJson{Err: err}
+func newTokenJson(token, refresh string) tokenJson {
+	return tokenJson{Token: token, RefreshToken: refresh}
 }
 
-func formateError(err error) string {
-	errStruct := newErrorJson(err.Error())
-	errJson, _ := json.Marshal(errStruct)
-	return string(errJson)
+func formateToken(token, refresh string) string {
+	tokenStruct := newTokenJson(token, refresh)
+	tokenJson, _ := json.Marshal(tokenStruct)
+	return string(tokenJson)
 }
 
 func (controller *Auth) RegisterUser(w http.ResponseWriter, r *http.Request) {
@@ -62,28 +79,30 @@ func (controller *Auth) RegisterUser(w http.ResponseWriter, r *http.Request) {
 
 	data := &userDTO{}
 	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
-		http.Error(w, formateError(ErrInvalidJson), http.StatusBadRequest)
+		errorfmt.Write(w, r, ErrInvalidJson)
 		return
 	}
 
 	if data.Username == "" || data.Password == "" {
-		http.Error(w, formateError(ErrInvalidDTO), http.StatusBadRequest)
+		errorfmt.Write(w, r, ErrInvalidDTO)
 		return
 	}
 	
-	if err := controller.ua.Register(data.Username, data.Password); err != nil {
-		http.Error(w, formateError(err), http.StatusConflict)
+	user, err := controller.ua.Register(r.Context(), data.Username, data.Password)
+	metrics.ObserveAuth("register", err == nil)
+	if err != nil {
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	tokenString, err := createToken()
+	tokenString, refresh, err := controller.issueTokens(user.ID)
 	if err != nil {
-		http.Error(w, formateError(err), http.StatusBadRequest)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintln(w, formateToken(string(tokenString)))
+	fmt.Fprintln(w, formateToken(tokenString, refresh))
 }
 
 func (controller *Auth) LoginUser(w http.ResponseWriter, r *http.Request) {
@@ -91,39 +110,208 @@ func (controller *Auth) LoginUser(w http.ResponseWriter, r *http.Request) {
 
 	data := &userDTO{}
 	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
-		http.Error(w, formateError(ErrInvalidJson), http.StatusBadRequest)
+		errorfmt.Write(w, r, ErrInvalidJson)
 		return
 	}
 
 	if data.Username == "" || data.Password == "" {
-		http.Error(w, formateError(ErrInvalidDTO), http.StatusBadRequest)
+		errorfmt.Write(w, r, ErrInvalidDTO)
 		return
 	}
 
-	err := controller.ua.Login(data.Username, data.Password)
+	user, err := controller.ua.Login(r.Context(), data.Username, data.Password)
+	metrics.ObserveAuth("login", err == nil)
 	if err != nil {
-		http.Error(w, formateError(err), http.StatusUnauthorized)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	tokenString, err := createToken()
+	tokenString, refresh, err := controller.issueTokens(user.ID)
 	if err != nil {
-		http.Error(w, formateError(err), http.StatusUnauthorized)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, formateToken(string(tokenString)))
+	fmt.Fprintln(w, formateToken(tokenString, refresh))
 }
 
-func createToken() (string, error) {
+func (controller *Auth) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	data := &refreshDTO{}
+	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+		errorfmt.Write(w, r, ErrInvalidJson)
+		return
+	}
+
+	if data.RefreshToken == "" {
+		errorfmt.Write(w, r, ErrNoRefresh)
+		return
+	}
+
+	subject, refresh, err := controller.ut.Rotate(r.Context(), data.RefreshToken)
+	metrics.ObserveAuth("refresh", err == nil)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	tokenString, err := controller.createToken(subject)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "signing access token failed", "err", err)
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, formateToken(tokenString, refresh))
+}
+
+func (controller *Auth) LogoutUser(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := security.CurrentUser(r.Context())
+	if !ok {
+		errorfmt.Write(w, r, ErrInvalidToken)
+		return
+	}
+
+	// the refresh token outlives the access token, logging out without it
+	// would leave the session restorable through /auth/refresh
+	data := &refreshDTO{}
+	if err := json.NewDecoder(r.Body).Decode(data); err != nil && !errors.Is(err, io.EOF) {
+		errorfmt.Write(w, r, ErrInvalidJson)
+		return
+	}
+	if data.RefreshToken == "" {
+		errorfmt.Write(w, r, ErrNoRefresh)
+		return
+	}
+	if _, err := controller.ut.DropRefresh(r.Context(), data.RefreshToken, principal.UserID); err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	if err := controller.ut.Revoke(principal.TokenID, principal.ExpiresAt); err != nil {
+		slog.ErrorContext(r.Context(), "revoking access token failed", "jti", principal.TokenID, "err", err)
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "user logged out", "jti", principal.TokenID)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+func (controller *Auth) GetAccount(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := security.CurrentUser(r.Context())
+	if !ok {
+		errorfmt.Write(w, r, ErrInvalidToken)
+		return
+	}
+
+	user, err := controller.ua.Account(r.Context(), principal.UserID)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	userJson, _ := json.Marshal(accountJson{UserID: user.ID, Username: user.Username, Currency: user.Currency})
+	w.WriteHeader(http.StatusOK)
+	w.Write(userJson)
+}
+
+func (controller *Auth) UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := security.CurrentUser(r.Context())
+	if !ok {
+		errorfmt.Write(w, r, ErrInvalidToken)
+		return
+	}
+
+	data := &accountDTO{}
+	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+		errorfmt.Write(w, r, ErrInvalidJson)
+		return
+	}
+
+	currency := strings.ToLower(data.Currency)
+	if err := controller.ua.SetCurrency(r.Context(), principal.UserID, currency); err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	controller.GetAccount(w, r)
+}
+
+func (controller *Auth) issueTokens(subject string) (string, string, error) {
+	tokenString, err := controller.createToken(subject)
+	if err != nil {
+		return "", "", err
+	}
+
+	refresh, err := controller.ut.IssueRefresh(subject)
+	if err != nil {
+		return "", "", err
+	}
+
+	return tokenString, refresh, nil
+}
+
+func (controller *Auth) createToken(subject string) (string, error) {
 	claims := &jwt.RegisteredClaims{
-		Subject:   uuid.NewString(),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * time.Minute)),
+		ID:        uuid.NewString(),
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(controller.ttl)),
 		IssuedAt:  jwt.NewNumericDate(time.Now()),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := []byte("super_secret_key_that_should_be_long_and_random") // fix this, need security
-	return token.SignedString(secret)
+	return token.SignedString(controller.secret)
+}
+
+// ParseToken validates an access token, makes sure it was not revoked by
+// a logout and returns the user it was issued to along with their default
+// currency.
+func (controller *Auth) ParseToken(ctx context.Context, tokenString string) (*security.Principal, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		if token.Method.Alg() != "HS256" {
+			return nil, fmt.Errorf("algo %v, expected HS256\n", token.Header["alg"])
+		}
+		return controller.secret, nil
+	}, jwt.WithExpirationRequired())
+
+	if err != nil || !token.Valid || claims.ID == "" || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	if err := controller.ut.CheckRevoked(claims.ID); err != nil {
+		return nil, err
+	}
+
+	currency := domain.DefaultCurrency
+	if user, err := controller.ua.Account(ctx, claims.Subject); err == nil {
+		currency = user.Currency
+	}
+
+	return &security.Principal{
+		UserID:    claims.Subject,
+		TokenID:   claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+		Currency:  currency,
+	}, nil
+}
+
+func BearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(authHeader, "Bearer ")
 }
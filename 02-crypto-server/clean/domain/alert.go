@@ -0,0 +1,47 @@
+package domain
+
+import "time"
+
+type AlertCondition string
+
+const (
+	AlertAbove  AlertCondition = "above"  // price >= threshold
+	AlertBelow  AlertCondition = "below"  // price <= threshold
+	AlertChange AlertCondition = "change" // change over window in %, the sign of threshold picks the direction
+)
+
+type AlertRule struct {
+	ID        string
+	UserID    string
+	CoinID    string
+	Symbol    string
+	Condition AlertCondition
+	Threshold float64
+	Window    time.Duration // AlertChange only
+	CreatedAt time.Time
+	Triggered bool // fired and not re-armed yet, the condition has to clear first
+}
+
+type AlertEvent struct {
+	ID        string
+	RuleID    string
+	UserID    string
+	CoinID    string
+	Symbol    string
+	Condition AlertCondition
+	Threshold float64
+	Price     float64
+	Change    float64 // AlertChange only
+	FiredAt   time.Time
+}
+
+type AlertStore interface {
+	SaveRule(rule *AlertRule) error
+	UpdateRule(rule *AlertRule) error // no-op if the rule was deleted meanwhile
+	DeleteRule(userID, ruleID string) (bool, error)
+	Rules(userID string) ([]AlertRule, error)
+	CoinRules(coinID string) ([]AlertRule, error)
+	Coins() ([]string, error) // coins with at least one rule
+	AddEvent(event *AlertEvent) error
+	Events(userID string, limit int) ([]AlertEvent, error) // newest first
+}
@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"context"
+	"cryptoserver/apperror"
+	"time"
+)
+
+var (
+	ErrNoID            = apperror.New(apperror.NotFound, "symbol_not_found", "No id by your symbol.")
+	ErrLimitExceeded   = apperror.New(apperror.RateLimited, "rate_limited", "Request limit exceeded.")
+	ErrInvalidCurrency = apperror.New(apperror.Validation, "invalid_currency", "Currency must be one of usd, eur, rub, btc.")
+	ErrNoPrice         = apperror.New(apperror.Upstream, "price_unavailable", "Price is not available in this currency.")
+)
+
+const DefaultCurrency = "usd"
+
+// Currencies are the quote currencies prices can be requested in.
+var Currencies = map[string]bool{"usd": true, "eur": true, "rub": true, "btc": true}
+
+// LimitExceeded is returned when the upstream budget is exhausted, it
+// matches ErrLimitExceeded with errors.Is.
+func LimitExceeded(retryAfter time.Duration) error {
+	return ErrLimitExceeded.WithRetryAfter(retryAfter)
+}
+
+type Coin struct {
+	ID           string
+	Symbol       string
+	Name         string
+	CurrentPrice float64            // in DefaultCurrency
+	Prices       map[string]float64 // current price by quote currency
+	LastUpdated  string
+}
+
+// Price returns the price in currency, ok is false if the coin was not
+// priced in it.
+func (c *Coin) Price(currency string) (float64, bool) {
+	if price, ok := c.Prices[currency]; ok {
+		return price, true
+	}
+	if currency == DefaultCurrency {
+		return c.CurrentPrice, true
+	}
+	return 0, false
+}
+
+// CoinListing is an entry of the provider's coin list.
+type CoinListing struct {
+	ID     string
+	Symbol string
+	Name   string
+}
+
+type PricePoint struct {
+	Price     float64
+	Timestamp time.Time
+}
+
+type MarketDataProvider interface {
+	Ping(ctx context.Context) error
+	SearchSymbol(ctx context.Context, symbol string) (string, error) // id or symbol -> id, an exact id wins
+	ListCoins(ctx context.Context) ([]CoinListing, error)
+	GetCoin(ctx context.Context, id string) (*Coin, error)
+	GetQuotes(ctx context.Context, ids []string, currency string) ([]Coin, error) // priced in currency only, unknown ids are left out
+	GetHistory(ctx context.Context, id, currency string, from, to time.Time) ([]PricePoint, error)
+}
@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"cmp"
+	"cryptoserver/apperror"
+	"time"
+)
+
+var ErrConcurrentUpdate = apperror.New(apperror.Conflict, "concurrent_update", "Transactions changed meanwhile, try again.")
+
+type TradeSide string
+
+const (
+	TradeBuy  TradeSide = "buy"
+	TradeSell TradeSide = "sell"
+)
+
+type Transaction struct {
+	ID        string
+	UserID    string
+	CoinID    string
+	Symbol    string
+	Side      TradeSide
+	Quantity  float64
+	Price     float64 // per coin, in Currency
+	Currency  string
+	Timestamp time.Time // when the trade happened, may be backdated
+	CreatedAt time.Time
+}
+
+// A PortfolioStore runs check on the user's transactions as they would be
+// after an add or delete, oldest trade first, and makes the change only if
+// check passes and nothing changed them in between. DeleteTransaction
+// reports false if there is no such transaction.
+type PortfolioStore interface {
+	AddTransaction(tx *Transaction, check func(txs []Transaction) error) error
+	DeleteTransaction(userID, id string, check func(txs []Transaction) error) (bool, error)
+	Transactions(userID string) ([]Transaction, error) // oldest trade first
+}
+
+// CompareTransactions orders transactions by trade time, then by entry.
+func CompareTransactions(a, b Transaction) int {
+	return cmp.Or(a.Timestamp.Compare(b.Timestamp), a.CreatedAt.Compare(b.CreatedAt))
+}
@@ -0,0 +1,8 @@
+package domain
+
+import "time"
+
+type PriceSeries interface {
+	Append(id string, point PricePoint) error
+	Range(id string, from, to time.Time) ([]PricePoint, error) // oldest first
+}
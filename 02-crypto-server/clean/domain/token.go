@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+type RefreshToken struct {
+	Token     string
+	Subject   string
+	ExpiresAt time.Time
+}
+
+type TokenStore interface {
+	SaveRefresh(token *RefreshToken) error
+	FindRefresh(token string) *RefreshToken // nil if unknown
+	TakeRefresh(token string) *RefreshToken // consumes the token, nil if unknown
+	Revoke(jti string, until time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
@@ -1,15 +1,24 @@
 package domain
 
+import "cryptoserver/apperror"
+
+// ErrUserAlreadyExists is returned by UserRepository.Save for a taken username.
+var ErrUserAlreadyExists = apperror.New(apperror.Conflict, "user_exists", "User already exists.")
+
 type User struct {
+	ID string
 	Username string
 	PasswordHash string
+	Currency string // default quote currency
 }
 
-func NewUser(username, passwordHash string) *User {
-	return &User{ Username: username, PasswordHash: passwordHash }
+func NewUser(id, username, passwordHash string) *User {
+	return &User{ ID: id, Username: username, PasswordHash: passwordHash, Currency: DefaultCurrency }
 }
 
 type UserRepository interface {
-	Save(user *User) error
+	Save(user *User) error // ErrUserAlreadyExists if the username is taken
 	Exist(username string) *User
+	Find(id string) *User
+	SetCurrency(id, currency string) (bool, error) // false if the user doesn't exist
 }
@@ -0,0 +1,43 @@
+package domain
+
+import "time"
+
+type Webhook struct {
+	ID        string
+	UserID    string
+	URL       string
+	Secret    string // HMAC key, shown to the user once on registration
+	CreatedAt time.Time
+}
+
+type DeliveryStatus string
+
+const (
+	DeliverySucceeded  DeliveryStatus = "succeeded"
+	DeliveryFailed     DeliveryStatus = "failed" // will be retried
+	DeliveryDeadLetter DeliveryStatus = "dead_letter"
+)
+
+// Delivery is one attempt to POST an event to a webhook.
+type Delivery struct {
+	ID         string // shared by all attempts of the same event and webhook
+	WebhookID  string
+	UserID     string
+	EventID    string
+	URL        string
+	Attempt    int
+	Status     DeliveryStatus
+	StatusCode int
+	Error      string
+	At         time.Time
+	Payload    []byte // kept for dead letters only, so the event is not lost
+}
+
+type WebhookStore interface {
+	SaveWebhook(hook *Webhook) error
+	DeleteWebhook(userID, id string) (bool, error)
+	Webhooks(userID string) ([]Webhook, error)
+	AddDelivery(delivery *Delivery) error
+	Deliveries(userID string, limit int) ([]Delivery, error)  // newest first
+	DeadLetters(userID string, limit int) ([]Delivery, error) // newest first
+}
@@ -1,14 +1,19 @@
 package usecase
 
 import (
+	"context"
+	"cryptoserver/apperror"
 	"cryptoserver/clean/domain"
 	"errors"
+	"log/slog"
+
+	"github.com/google/uuid"
 )
 
 var (
-	ErrUserAlreadyExists = errors.New("User already exists.")
-	ErrUserNotExists     = errors.New("User doesn't exist. Please register first.")
-	ErrWrongPassword     = errors.New("Wrong password.")
+	ErrUserAlreadyExists = domain.ErrUserAlreadyExists
+	ErrUserNotExists     = apperror.New(apperror.Unauthorized, "user_not_found", "User doesn't exist. Please register first.")
+	ErrWrongPassword     = apperror.New(apperror.Unauthorized, "wrong_password", "Wrong password.")
 )
 
 type Auth struct {
@@ -20,34 +25,71 @@ func NewAuth(ur domain.UserRepository, h domain.Hasher) *Auth {
 	return &Auth{ur: ur, h: h}
 }
 
-func (usecase *Auth) Register(username, password string) error {
+func (usecase *Auth) Register(ctx context.Context, username, password string) (*domain.User, error) {
 	if user := usecase.ur.Exist(username); user != nil {
-		return ErrUserAlreadyExists
+		slog.InfoContext(ctx, "registration rejected", "username", username, "reason", ErrUserAlreadyExists)
+		return nil, ErrUserAlreadyExists
 	}
 
 	hash, err := usecase.h.HashPassword(password)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	user := domain.NewUser(username, hash)
-	if err := usecase.ur.Save(user); err != nil {
-		return err
+	user := domain.NewUser(uuid.NewString(), username, hash)
+	// Exist is only a fast path, a concurrent registration can still take
+	// the username before Save
+	if err := usecase.ur.Save(user); errors.Is(err, ErrUserAlreadyExists) {
+		slog.InfoContext(ctx, "registration rejected", "username", username, "reason", ErrUserAlreadyExists)
+		return nil, ErrUserAlreadyExists
+	} else if err != nil {
+		slog.ErrorContext(ctx, "saving user failed", "username", username, "err", err)
+		return nil, err
 	}
 	
+	slog.InfoContext(ctx, "user registered", "username", username, "user_id", user.ID)
+	return user, nil
+}
+
+// Account returns the user the access token was issued to.
+func (usecase *Auth) Account(ctx context.Context, userID string) (*domain.User, error) {
+	user := usecase.ur.Find(userID)
+	if user == nil {
+		return nil, ErrUserNotExists
+	}
+	return user, nil
+}
+
+func (usecase *Auth) SetCurrency(ctx context.Context, userID, currency string) error {
+	if !domain.Currencies[currency] {
+		return domain.ErrInvalidCurrency
+	}
+
+	found, err := usecase.ur.SetCurrency(userID, currency)
+	if err != nil {
+		slog.ErrorContext(ctx, "saving currency failed", "user_id", userID, "err", err)
+		return err
+	} else if !found {
+		return ErrUserNotExists
+	}
+
+	slog.InfoContext(ctx, "default currency changed", "user_id", userID, "currency", currency)
 	return nil
 }
 
-func (usecase *Auth) Login(username, password string)  error {
+func (usecase *Auth) Login(ctx context.Context, username, password string) (*domain.User, error) {
 	user := usecase.ur.Exist(username)
 	if user == nil {
-		return ErrUserNotExists
+		slog.InfoContext(ctx, "login rejected", "username", username, "reason", ErrUserNotExists)
+		return nil, ErrUserNotExists
 	}
 
 	hash := user.PasswordHash
 	if !usecase.h.CheckPassword(hash, password) {
-		return ErrWrongPassword
+		slog.WarnContext(ctx, "login rejected", "username", username, "reason", ErrWrongPassword)
+		return nil, ErrWrongPassword
 	}
 
-	return nil
+	slog.InfoContext(ctx, "user logged in", "username", username, "user_id", user.ID)
+	return user, nil
 }
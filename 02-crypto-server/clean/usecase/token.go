@@ -0,0 +1,94 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"encoding/base64"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = apperror.New(apperror.Unauthorized, "invalid_refresh_token", "Invalid refresh token.")
+	ErrRevokedToken        = apperror.New(apperror.Unauthorized, "revoked_token", "Token has been revoked.")
+)
+
+type Token struct {
+	ts         domain.TokenStore
+	refreshTTL time.Duration
+}
+
+func NewToken(ts domain.TokenStore, refreshTTL time.Duration) *Token {
+	return &Token{ts: ts, refreshTTL: refreshTTL}
+}
+
+func (usecase *Token) IssueRefresh(subject string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	token := &domain.RefreshToken{
+		Token:     base64.RawURLEncoding.EncodeToString(buf),
+		Subject:   subject,
+		ExpiresAt: time.Now().Add(usecase.refreshTTL),
+	}
+	if err := usecase.ts.SaveRefresh(token); err != nil {
+		return "", err
+	}
+
+	return token.Token, nil
+}
+
+// Rotate consumes the refresh token and returns its subject together with a
+// freshly issued replacement. A token can be used only once.
+func (usecase *Token) Rotate(ctx context.Context, refresh string) (string, string, error) {
+	old := usecase.ts.TakeRefresh(refresh)
+	if old == nil {
+		slog.WarnContext(ctx, "refresh rejected", "reason", ErrInvalidRefreshToken)
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	next, err := usecase.IssueRefresh(old.Subject)
+	if err != nil {
+		slog.ErrorContext(ctx, "issuing refresh token failed", "user_id", old.Subject, "err", err)
+		return "", "", err
+	}
+
+	slog.InfoContext(ctx, "refresh token rotated", "user_id", old.Subject)
+	return old.Subject, next, nil
+}
+
+// DropRefresh consumes the refresh token of subject so it can no longer
+// restore the session. A token that is unknown or was issued to someone else
+// is left alone.
+func (usecase *Token) DropRefresh(ctx context.Context, refresh, subject string) (*domain.RefreshToken, error) {
+	stored := usecase.ts.FindRefresh(refresh)
+	if stored == nil || stored.Subject != subject {
+		slog.WarnContext(ctx, "logout rejected", "user_id", subject, "reason", ErrInvalidRefreshToken)
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// rotated in the meantime, the replacement is what restores the session
+	if usecase.ts.TakeRefresh(refresh) == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return stored, nil
+}
+
+func (usecase *Token) Revoke(jti string, until time.Time) error {
+	return usecase.ts.Revoke(jti, until)
+}
+
+func (usecase *Token) CheckRevoked(jti string) error {
+	revoked, err := usecase.ts.IsRevoked(jti)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrRevokedToken
+	}
+	return nil
+}
@@ -0,0 +1,446 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are resolved in the following order, later sources win:
+// defaults, YAML file (-config or CRYPTOSERVER_CONFIG), environment, flags.
+const envPrefix = "CRYPTOSERVER_"
+
+// leakedSecret was once the hardcoded signing key, it is public in the
+// history of this repository.
+const leakedSecret = "super_secret_key_that_should_be_long_and_random"
+
+type Server struct {
+	Addr            string        `yaml:"addr"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type Log struct {
+	Level  string `yaml:"level"`  // debug | info | warn | error
+	Format string `yaml:"format"` // json | text
+}
+
+type Redis struct {
+	Addr string `yaml:"addr"`
+}
+
+type CoinGecko struct {
+	RootURL           string        `yaml:"root_url"`
+	APIKey            string        `yaml:"api_key"`
+	Timeout           time.Duration `yaml:"timeout"`
+	RequestsPerMinute int           `yaml:"requests_per_minute"`
+	Burst             int           `yaml:"burst"`
+}
+
+type Cache struct {
+	IDTTL time.Duration `yaml:"id_ttl"`
+	// CoinTTL is how long coins and histories are fresh, until CoinHardTTL
+	// they are still served while being refreshed.
+	CoinTTL     time.Duration `yaml:"coin_ttl"`
+	CoinHardTTL time.Duration `yaml:"coin_hard_ttl"`
+	WatchTTL    time.Duration `yaml:"watch_ttl"`
+	// The in-process tier in front of redis keeps up to LocalSize symbol
+	// ids and LocalBytes of coins and of histories each, for LocalTTL.
+	LocalSize  int           `yaml:"local_size"`
+	LocalBytes int           `yaml:"local_bytes"`
+	LocalTTL   time.Duration `yaml:"local_ttl"`
+}
+
+type Series struct {
+	Retention time.Duration `yaml:"retention"`
+}
+
+type Collector struct {
+	Interval          time.Duration `yaml:"interval"`
+	RequestsPerMinute int           `yaml:"requests_per_minute"`
+}
+
+type Auth struct {
+	JWTSecret  string        `yaml:"jwt_secret"`
+	TokenTTL   time.Duration `yaml:"token_ttl"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl"`
+}
+
+type Symbols struct {
+	RefreshInterval time.Duration     `yaml:"refresh_interval"` // how often the coin list is reloaded
+	Pinned          map[string]string `yaml:"pinned"`           // symbol -> id, wins over other coins sharing the symbol
+}
+
+type Stream struct {
+	QueueSize int    `yaml:"queue_size"` // pending ticks per SSE or WebSocket client
+	Overflow  string `yaml:"overflow"`   // drop | disconnect, what happens to a client whose queue is full
+}
+
+type Webhooks struct {
+	Timeout     time.Duration `yaml:"timeout"`
+	MaxAttempts int           `yaml:"max_attempts"` // dead-lettered after this many failures
+	Backoff     time.Duration `yaml:"backoff"`      // doubled after every failed attempt
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+	QueueSize   int           `yaml:"queue_size"`
+	// AllowPrivate lets webhooks reach loopback, private and link-local
+	// addresses, for local development only.
+	AllowPrivate bool `yaml:"allow_private"`
+}
+
+type Storage struct {
+	Backend    string `yaml:"backend"` // sqlite | memory
+	SQLitePath string `yaml:"sqlite_path"`
+}
+
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Log       Log       `yaml:"log"`
+	Redis     Redis     `yaml:"redis"`
+	CoinGecko CoinGecko `yaml:"coingecko"`
+	Cache     Cache     `yaml:"cache"`
+	Collector Collector `yaml:"collector"`
+	Series    Series    `yaml:"series"`
+	Symbols   Symbols   `yaml:"symbols"`
+	Auth      Auth      `yaml:"auth"`
+	Stream    Stream    `yaml:"stream"`
+	Webhooks  Webhooks  `yaml:"webhooks"`
+	Storage   Storage   `yaml:"storage"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:            ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Log:   Log{Level: "info", Format: "json"},
+		Redis: Redis{Addr: "localhost:6379"},
+		CoinGecko: CoinGecko{
+			RootURL:           "https://api.coingecko.com/api/v3",
+			Timeout:           15 * time.Second,
+			RequestsPerMinute: 30,
+			Burst:             5,
+		},
+		Cache: Cache{
+			IDTTL:       30 * time.Minute,
+			CoinTTL:     15 * time.Minute,
+			CoinHardTTL: 2 * time.Hour,
+			WatchTTL:    15 * time.Minute,
+			LocalSize:   10000,
+			LocalBytes:  16 << 20,
+			LocalTTL:    5 * time.Second,
+		},
+		Collector: Collector{
+			Interval:          time.Minute,
+			RequestsPerMinute: 15,
+		},
+		Series: Series{
+			Retention: 8 * 24 * time.Hour,
+		},
+		Auth: Auth{
+			TokenTTL:   30 * time.Minute,
+			RefreshTTL: 7 * 24 * time.Hour,
+		},
+		Symbols: Symbols{
+			RefreshInterval: 12 * time.Hour,
+			Pinned: map[string]string{
+				"btc":  "bitcoin",
+				"eth":  "ethereum",
+				"usdt": "tether",
+				"usdc": "usd-coin",
+				"bnb":  "binancecoin",
+				"sol":  "solana",
+				"xrp":  "ripple",
+				"ada":  "cardano",
+				"doge": "dogecoin",
+			},
+		},
+		Stream: Stream{
+			QueueSize: 64,
+			Overflow:  "disconnect",
+		},
+		Webhooks: Webhooks{
+			Timeout:     10 * time.Second,
+			MaxAttempts: 5,
+			Backoff:     time.Second,
+			MaxBackoff:  5 * time.Minute,
+			QueueSize:   256,
+		},
+		Storage: Storage{
+			Backend:    "sqlite",
+			SQLitePath: "cryptoserver.db",
+		},
+	}
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v stringValue) Set(s string) error {
+	*v.p = s
+	return nil
+}
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return v.p.String()
+}
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v.p = d
+	return nil
+}
+
+type intValue struct{ p *int }
+
+func (v intValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.Itoa(*v.p)
+}
+
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v.p = n
+	return nil
+}
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v.p)
+}
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v.p = b
+	return nil
+}
+
+func (v boolValue) IsBoolFlag() bool { return true }
+
+type field struct {
+	name  string
+	usage string
+	value flag.Value
+}
+
+func (f field) env() string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(f.name))
+}
+
+func fields(cfg *Config) []field {
+	return []field{
+		{"server.addr", "listen address", stringValue{&cfg.Server.Addr}},
+		{"server.read-timeout", "max time to read a request", durationValue{&cfg.Server.ReadTimeout}},
+		{"server.write-timeout", "max time to write a response", durationValue{&cfg.Server.WriteTimeout}},
+		{"server.idle-timeout", "keep-alive idle timeout", durationValue{&cfg.Server.IdleTimeout}},
+		{"server.shutdown-timeout", "drain deadline on shutdown", durationValue{&cfg.Server.ShutdownTimeout}},
+		{"log.level", "log level: debug, info, warn or error", stringValue{&cfg.Log.Level}},
+		{"log.format", "log format: json or text", stringValue{&cfg.Log.Format}},
+		{"redis.addr", "redis address", stringValue{&cfg.Redis.Addr}},
+		{"coingecko.root-url", "CoinGecko API root URL", stringValue{&cfg.CoinGecko.RootURL}},
+		{"coingecko.api-key", "CoinGecko demo API key", stringValue{&cfg.CoinGecko.APIKey}},
+		{"coingecko.timeout", "upstream HTTP client timeout", durationValue{&cfg.CoinGecko.Timeout}},
+		{"coingecko.requests-per-minute", "upstream request budget shared by all callers", intValue{&cfg.CoinGecko.RequestsPerMinute}},
+		{"coingecko.burst", "upstream requests allowed at once", intValue{&cfg.CoinGecko.Burst}},
+		{"cache.id-ttl", "symbol -> id cache TTL", durationValue{&cfg.Cache.IDTTL}},
+		{"cache.coin-ttl", "coin, history and stats cache TTL", durationValue{&cfg.Cache.CoinTTL}},
+		{"cache.coin-hard-ttl", "how long stale coins and histories are served", durationValue{&cfg.Cache.CoinHardTTL}},
+		{"cache.watch-ttl", "watched coin snapshot TTL", durationValue{&cfg.Cache.WatchTTL}},
+		{"cache.local-size", "symbol ids kept in the in-process cache", intValue{&cfg.Cache.LocalSize}},
+		{"cache.local-bytes", "bytes of coins and of histories each kept in the in-process cache", intValue{&cfg.Cache.LocalBytes}},
+		{"cache.local-ttl", "in-process cache TTL", durationValue{&cfg.Cache.LocalTTL}},
+		{"collector.interval", "pause between background refresh runs", durationValue{&cfg.Collector.Interval}},
+		{"collector.requests-per-minute", "upstream request budget of the background collector", intValue{&cfg.Collector.RequestsPerMinute}},
+		{"series.retention", "how long sampled prices are kept", durationValue{&cfg.Series.Retention}},
+		{"auth.jwt-secret", "HS256 signing secret", stringValue{&cfg.Auth.JWTSecret}},
+		{"auth.token-ttl", "access token lifetime", durationValue{&cfg.Auth.TokenTTL}},
+		{"auth.refresh-ttl", "refresh token lifetime", durationValue{&cfg.Auth.RefreshTTL}},
+		{"symbols.refresh-interval", "how often the symbol index is reloaded from the coin list", durationValue{&cfg.Symbols.RefreshInterval}},
+		{"stream.queue-size", "pending ticks per streaming client", intValue{&cfg.Stream.QueueSize}},
+		{"stream.overflow", "slow streaming client policy: drop or disconnect", stringValue{&cfg.Stream.Overflow}},
+		{"webhooks.timeout", "webhook delivery HTTP timeout", durationValue{&cfg.Webhooks.Timeout}},
+		{"webhooks.max-attempts", "webhook delivery attempts before dead-lettering", intValue{&cfg.Webhooks.MaxAttempts}},
+		{"webhooks.backoff", "delay before the first webhook retry", durationValue{&cfg.Webhooks.Backoff}},
+		{"webhooks.max-backoff", "upper bound of the webhook retry delay", durationValue{&cfg.Webhooks.MaxBackoff}},
+		{"webhooks.queue-size", "pending webhook deliveries before new ones are dead-lettered", intValue{&cfg.Webhooks.QueueSize}},
+		{"webhooks.allow-private", "allow webhooks to non-public addresses", boolValue{&cfg.Webhooks.AllowPrivate}},
+		{"storage.backend", "user storage backend: sqlite or memory", stringValue{&cfg.Storage.Backend}},
+		{"storage.sqlite-path", "SQLite database file", stringValue{&cfg.Storage.SQLitePath}},
+	}
+}
+
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("cryptoserver", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path to YAML config file")
+	for _, f := range fields(Default()) {
+		fs.Var(f.value, f.name, fmt.Sprintf("%s (env %s)", f.usage, f.env()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if *path != "" {
+		if err := loadFile(*path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	bound := fields(cfg)
+	for _, f := range bound {
+		if v, ok := os.LookupEnv(f.env()); ok {
+			if err := f.value.Set(v); err != nil {
+				return nil, fmt.Errorf("config: %s: %w", f.env(), err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range bound {
+			if f.name == fl.Name && flagErr == nil {
+				flagErr = f.value.Set(fl.Value.String())
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	// yaml merges into a map, pinned symbols listed in the file replace the
+	// defaults instead so that a default can be removed
+	pinned := cfg.Symbols.Pinned
+	cfg.Symbols.Pinned = nil
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	if cfg.Symbols.Pinned == nil {
+		cfg.Symbols.Pinned = pinned
+	}
+	return nil
+}
+
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("config: "+format, args...))
+		}
+	}
+
+	check(cfg.Server.Addr != "", "server.addr is required")
+	check(cfg.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(cfg.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(cfg.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(cfg.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(cfg.Log.Level)) == nil, "log.level %q is not a slog level", cfg.Log.Level)
+	check(cfg.Log.Format == "json" || cfg.Log.Format == "text", "log.format %q must be json or text", cfg.Log.Format)
+	check(cfg.Redis.Addr != "", "redis.addr is required")
+
+	u, err := url.Parse(cfg.CoinGecko.RootURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"coingecko.root_url %q is not an http(s) URL", cfg.CoinGecko.RootURL)
+	check(cfg.CoinGecko.Timeout > 0, "coingecko.timeout must be positive")
+	check(cfg.CoinGecko.RequestsPerMinute > 0, "coingecko.requests_per_minute must be positive")
+	check(cfg.CoinGecko.Burst > 0, "coingecko.burst must be positive")
+
+	check(cfg.Cache.IDTTL > 0, "cache.id_ttl must be positive")
+	check(cfg.Cache.CoinTTL > 0, "cache.coin_ttl must be positive")
+	check(cfg.Cache.CoinHardTTL >= cfg.Cache.CoinTTL, "cache.coin_hard_ttl must not be shorter than cache.coin_ttl")
+	check(cfg.Cache.WatchTTL > 0, "cache.watch_ttl must be positive")
+	check(cfg.Cache.LocalSize > 0, "cache.local_size must be positive")
+	check(cfg.Cache.LocalBytes > 0, "cache.local_bytes must be positive")
+	check(cfg.Cache.LocalTTL > 0, "cache.local_ttl must be positive")
+
+	check(cfg.Collector.Interval > 0, "collector.interval must be positive")
+	check(cfg.Collector.RequestsPerMinute > 0, "collector.requests_per_minute must be positive")
+	check(cfg.Collector.RequestsPerMinute <= cfg.CoinGecko.RequestsPerMinute,
+		"collector.requests_per_minute must not exceed coingecko.requests_per_minute")
+
+	check(cfg.Series.Retention >= 7*24*time.Hour, "series.retention must cover the 7d stats window")
+
+	check(cfg.Auth.JWTSecret != "", "auth.jwt_secret is required")
+	check(cfg.Auth.JWTSecret == "" || len(cfg.Auth.JWTSecret) >= 32, "auth.jwt_secret must be at least 32 bytes")
+	check(cfg.Auth.JWTSecret != leakedSecret, "auth.jwt_secret must not be the publicly known example value")
+	check(cfg.Auth.TokenTTL > 0, "auth.token_ttl must be positive")
+	check(cfg.Auth.RefreshTTL > cfg.Auth.TokenTTL, "auth.refresh_ttl must be longer than auth.token_ttl")
+
+	check(cfg.Symbols.RefreshInterval > 0, "symbols.refresh_interval must be positive")
+
+	check(cfg.Stream.QueueSize > 0, "stream.queue_size must be positive")
+	check(cfg.Stream.Overflow == "drop" || cfg.Stream.Overflow == "disconnect",
+		"stream.overflow %q must be drop or disconnect", cfg.Stream.Overflow)
+
+	check(cfg.Webhooks.Timeout > 0, "webhooks.timeout must be positive")
+	check(cfg.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+	check(cfg.Webhooks.Backoff > 0, "webhooks.backoff must be positive")
+	check(cfg.Webhooks.MaxBackoff >= cfg.Webhooks.Backoff, "webhooks.max_backoff must not be below webhooks.backoff")
+	check(cfg.Webhooks.QueueSize > 0, "webhooks.queue_size must be positive")
+
+	switch cfg.Storage.Backend {
+	case "sqlite":
+		check(cfg.Storage.SQLitePath != "", "storage.sqlite_path is required for sqlite backend")
+	case "memory":
+	default:
+		check(false, "storage.backend %q must be sqlite or memory", cfg.Storage.Backend)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		wantAddr string
+		wantTTL  time.Duration
+	}{
+		{
+			name:     "defaults",
+			wantAddr: ":8080",
+			wantTTL:  15 * time.Minute,
+		},
+		{
+			name:     "file overrides defaults",
+			file:     "server:\n  addr: \":9000\"\ncache:\n  coin_ttl: 1m\n",
+			wantAddr: ":9000",
+			wantTTL:  time.Minute,
+		},
+		{
+			name:     "env overrides file",
+			file:     "server:\n  addr: \":9000\"\ncache:\n  coin_ttl: 1m\n",
+			env:      map[string]string{"CRYPTOSERVER_SERVER_ADDR": ":9100"},
+			wantAddr: ":9100",
+			wantTTL:  time.Minute,
+		},
+		{
+			name:     "flag overrides env",
+			file:     "server:\n  addr: \":9000\"\n",
+			env:      map[string]string{"CRYPTOSERVER_SERVER_ADDR": ":9100", "CRYPTOSERVER_CACHE_COIN_TTL": "2m"},
+			args:     []string{"-server.addr", ":9200"},
+			wantAddr: ":9200",
+			wantTTL:  2 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CRYPTOSERVER_AUTH_JWT_SECRET", testSecret)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+
+			cfg, err := Load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Addr != tt.wantAddr {
+				t.Errorf("server.addr = %q, want %q", cfg.Server.Addr, tt.wantAddr)
+			}
+			if cfg.Cache.CoinTTL != tt.wantTTL {
+				t.Errorf("cache.coin_ttl = %v, want %v", cfg.Cache.CoinTTL, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		args    []string
+		wantErr string
+	}{
+		{name: "missing secret", wantErr: "auth.jwt_secret is required"},
+		{name: "short secret", secret: "short", wantErr: "at least 32 bytes"},
+		{name: "leaked secret", secret: leakedSecret, wantErr: "publicly known"},
+		{name: "bad duration", secret: testSecret, args: []string{"-cache.coin-ttl", "soon"}, wantErr: "invalid value"},
+		{name: "hard ttl below ttl", secret: testSecret, args: []string{"-cache.coin-hard-ttl", "1m"}, wantErr: "coin_hard_ttl"},
+		{name: "unknown overflow", secret: testSecret, args: []string{"-stream.overflow", "block"}, wantErr: "drop or disconnect"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CRYPTOSERVER_AUTH_JWT_SECRET", tt.secret)
+			_, err := Load(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		wantPinned map[string]string
+		wantErr    string
+	}{
+		{
+			name:       "empty file keeps the defaults",
+			file:       "",
+			wantPinned: Default().Symbols.Pinned,
+		},
+		{
+			name:       "pinned symbols replace the defaults",
+			file:       "symbols:\n  pinned:\n    btc: bitcoin\n    sol: solana-wormhole\n",
+			wantPinned: map[string]string{"btc": "bitcoin", "sol": "solana-wormhole"},
+		},
+		{
+			name:       "all pins can be removed",
+			file:       "symbols:\n  pinned: {}\n",
+			wantPinned: map[string]string{},
+		},
+		{
+			name:    "unknown key",
+			file:    "cache:\n  coin_tll: 1m\n",
+			wantErr: "field coin_tll not found",
+		},
+		{
+			name:    "unknown section",
+			file:    "cahce:\n  coin_ttl: 1m\n",
+			wantErr: "field cahce not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CRYPTOSERVER_AUTH_JWT_SECRET", testSecret)
+			cfg, err := Load([]string{"-config", writeConfig(t, tt.file)})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(cfg.Symbols.Pinned, tt.wantPinned) {
+				t.Errorf("pinned = %v, want %v", cfg.Symbols.Pinned, tt.wantPinned)
+			}
+		})
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	t.Setenv("CRYPTOSERVER_AUTH_JWT_SECRET", testSecret)
+	if _, err := Load([]string{"-config", "../config.example.yaml"}); err != nil {
+		t.Fatal(err)
+	}
+}
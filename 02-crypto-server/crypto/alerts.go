@@ -0,0 +1,328 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"cryptoserver/errorfmt"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultEventsLimit = 50
+	maxEventsLimit     = 100
+)
+
+var (
+	ErrInvalidCondition = apperror.New(apperror.Validation, "invalid_condition", "Condition must be one of above, below, change.")
+	ErrInvalidThreshold = apperror.New(apperror.Validation, "invalid_threshold", "Threshold must be positive for above/below and non-zero for change.")
+	ErrInvalidLimit     = apperror.New(apperror.Validation, "invalid_limit", "Limit must be between 1 and 100.")
+	ErrAlertNotFound    = apperror.New(apperror.NotFound, "alert_not_found", "Alert doesn't exist.")
+)
+
+type AlertRequest struct {
+	Symbol    string  `json:"symbol"`
+	Condition string  `json:"condition"`
+	Threshold float64 `json:"threshold"`
+	Window    string  `json:"window,omitempty"`
+}
+
+type AlertResponse struct {
+	ID        string    `json:"id"`
+	Symbol    string    `json:"symbol"`
+	Condition string    `json:"condition"`
+	Threshold float64   `json:"threshold"`
+	Window    string    `json:"window,omitempty"`
+	Triggered bool      `json:"triggered"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AlertEventResponse struct {
+	ID        string    `json:"id"`
+	RuleID    string    `json:"rule_id"`
+	Symbol    string    `json:"symbol"`
+	Condition string    `json:"condition"`
+	Threshold float64   `json:"threshold"`
+	Price     float64   `json:"price"`
+	Change    float64   `json:"change,omitempty"`
+	FiredAt   time.Time `json:"fired_at"`
+}
+
+func windowName(d time.Duration) string {
+	for name, span := range windows {
+		if span == d {
+			return name
+		}
+	}
+	return d.String()
+}
+
+func newAlertResponse(rule *domain.AlertRule) AlertResponse {
+	response := AlertResponse{
+		ID:        rule.ID,
+		Symbol:    rule.Symbol,
+		Condition: string(rule.Condition),
+		Threshold: rule.Threshold,
+		Triggered: rule.Triggered,
+		CreatedAt: rule.CreatedAt,
+	}
+	if rule.Condition == domain.AlertChange {
+		response.Window = windowName(rule.Window)
+	}
+	return response
+}
+
+func newAlertEventResponse(event *domain.AlertEvent) AlertEventResponse {
+	return AlertEventResponse{
+		ID:        event.ID,
+		RuleID:    event.RuleID,
+		Symbol:    event.Symbol,
+		Condition: string(event.Condition),
+		Threshold: event.Threshold,
+		Price:     event.Price,
+		Change:    event.Change,
+		FiredAt:   event.FiredAt,
+	}
+}
+
+func (request *AlertRequest) rule() (*domain.AlertRule, error) {
+	rule := &domain.AlertRule{
+		Symbol:    request.Symbol,
+		Condition: domain.AlertCondition(request.Condition),
+		Threshold: request.Threshold,
+	}
+
+	switch rule.Condition {
+	case domain.AlertAbove, domain.AlertBelow:
+		if rule.Threshold <= 0 {
+			return nil, ErrInvalidThreshold
+		}
+	case domain.AlertChange:
+		if rule.Threshold == 0 {
+			return nil, ErrInvalidThreshold
+		}
+		span, err := parseWindow(request.Window)
+		if err != nil {
+			return nil, err
+		}
+		rule.Window = span
+	default:
+		return nil, ErrInvalidCondition
+	}
+	return rule, nil
+}
+
+func (api *API) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	rules, err := api.alerts.Rules(userID)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	alerts := make([]AlertResponse, len(rules))
+	for i := range rules {
+		alerts[i] = newAlertResponse(&rules[i])
+	}
+
+	clientJSON, err := json.Marshal(map[string][]AlertResponse{"alerts": alerts})
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(clientJSON)
+}
+
+func (api *API) CreateAlert(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	request := AlertRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		errorfmt.Write(w, r, ErrInvalidJSON)
+		return
+	}
+
+	rule, err := request.rule()
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	id, err := api.getID(r.Context(), request.Symbol)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	rule.ID = uuid.NewString()
+	rule.UserID = userID
+	rule.CoinID = id
+	rule.CreatedAt = time.Now().UTC()
+	if err := api.alerts.SaveRule(rule); err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	clientJSON, err := json.Marshal(newAlertResponse(rule))
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(clientJSON)
+}
+
+func (api *API) DeleteAlert(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := api.alerts.DeleteRule(userID, chi.URLParam(r, "id"))
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	} else if !deleted {
+		errorfmt.Write(w, r, ErrAlertNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+func (api *API) ListAlertEvents(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultEventsLimit
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > maxEventsLimit {
+			errorfmt.Write(w, r, ErrInvalidLimit)
+			return
+		}
+	}
+
+	events, err := api.alerts.Events(userID, limit)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	formatedEvents := make([]AlertEventResponse, len(events))
+	for i := range events {
+		formatedEvents[i] = newAlertEventResponse(&events[i])
+	}
+
+	clientJSON, err := json.Marshal(map[string][]AlertEventResponse{"events": formatedEvents})
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(clientJSON)
+}
+
+// checkRule reports whether the rule's condition holds at price. Change rules
+// compare against the oldest sample inside their window.
+func (api *API) checkRule(rule *domain.AlertRule, price float64, now time.Time) (bool, float64, error) {
+	switch rule.Condition {
+	case domain.AlertAbove:
+		return price >= rule.Threshold, 0, nil
+	case domain.AlertBelow:
+		return price <= rule.Threshold, 0, nil
+	}
+
+	samples, err := api.series.Range(rule.CoinID, now.Add(-rule.Window), now)
+	if err != nil {
+		return false, 0, err
+	}
+	samples = append(samples, domain.PricePoint{Price: price, Timestamp: now})
+	change := computeRecord(samples).PriceChangePercent
+	if rule.Threshold > 0 {
+		return change >= rule.Threshold, change, nil
+	}
+	return change <= rule.Threshold, change, nil
+}
+
+// evaluateAlerts fires every rule on coin whose condition started to hold.
+// A fired rule stays quiet until its condition clears, so a price hovering
+// above a threshold fires once instead of on every collector run.
+func (api *API) evaluateAlerts(ctx context.Context, coin *domain.Coin) {
+	rules, err := api.alerts.CoinRules(coin.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "loading alert rules failed", "coin", coin.ID, "err", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	for i := range rules {
+		rule := &rules[i]
+		met, change, err := api.checkRule(rule, coin.CurrentPrice, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "checking alert rule failed", "rule", rule.ID, "err", err)
+			continue
+		}
+		if met == rule.Triggered {
+			continue
+		}
+
+		rule.Triggered = met
+		if err := api.alerts.UpdateRule(rule); err != nil {
+			slog.ErrorContext(ctx, "updating alert rule failed", "rule", rule.ID, "err", err)
+			continue
+		}
+		if !met {
+			continue
+		}
+
+		event := &domain.AlertEvent{
+			ID:        uuid.NewString(),
+			RuleID:    rule.ID,
+			UserID:    rule.UserID,
+			CoinID:    rule.CoinID,
+			Symbol:    rule.Symbol,
+			Condition: rule.Condition,
+			Threshold: rule.Threshold,
+			Price:     coin.CurrentPrice,
+			Change:    change,
+			FiredAt:   now,
+		}
+		if err := api.alerts.AddEvent(event); err != nil {
+			slog.ErrorContext(ctx, "recording alert event failed", "rule", rule.ID, "err", err)
+			continue
+		}
+		slog.InfoContext(ctx, "alert fired", "rule", rule.ID, "user", rule.UserID,
+			"coin", coin.ID, "price", coin.CurrentPrice)
+	}
+}
@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCreateAlertInvalid(t *testing.T) {
+	ta := newTestAPI(t)
+	ta.loadSymbols(t)
+
+	tests := []struct {
+		name       string
+		body       any
+		wantStatus int
+		wantCode   string
+	}{
+		{"bad body", "btc", http.StatusBadRequest, "invalid_json"},
+		{"unknown condition", AlertRequest{Symbol: "btc", Condition: "equals", Threshold: 1}, http.StatusBadRequest, "invalid_condition"},
+		{"non-positive price", AlertRequest{Symbol: "btc", Condition: "above", Threshold: 0}, http.StatusBadRequest, "invalid_threshold"},
+		{"zero change", AlertRequest{Symbol: "btc", Condition: "change", Threshold: 0, Window: "1h"}, http.StatusBadRequest, "invalid_threshold"},
+		{"unknown window", AlertRequest{Symbol: "btc", Condition: "change", Threshold: 5, Window: "2h"}, http.StatusBadRequest, "invalid_window"},
+		{"unknown symbol", AlertRequest{Symbol: "doge", Condition: "above", Threshold: 1}, http.StatusNotFound, "symbol_not_found"},
+	}
+
+	for _, tt := range tests {
+		rec := ta.do(t, "alice", http.MethodPost, "/alerts", tt.body, nil)
+		if rec.Code != tt.wantStatus || problemCode(rec) != tt.wantCode {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, rec.Code, problemCode(rec), tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+func TestAlertsPerUser(t *testing.T) {
+	ta := newTestAPI(t)
+	ta.loadSymbols(t)
+
+	var created AlertResponse
+	rec := ta.do(t, "alice", http.MethodPost, "/alerts", AlertRequest{Symbol: "eth", Condition: "change", Threshold: -5}, &created)
+	if rec.Code != http.StatusCreated || created.Window != defaultWindow {
+		t.Fatalf("create: %d %s, want 201 with the default window", rec.Code, rec.Body)
+	}
+
+	alerts := func(user string) []AlertResponse {
+		var list struct {
+			Alerts []AlertResponse `json:"alerts"`
+		}
+		ta.do(t, user, http.MethodGet, "/alerts", nil, &list)
+		return list.Alerts
+	}
+	if got := alerts("alice"); len(got) != 1 || got[0].ID != created.ID {
+		t.Errorf("alice's alerts = %+v, want the created one", got)
+	}
+	if got := alerts("bob"); len(got) != 0 {
+		t.Errorf("bob's alerts = %+v, want none", got)
+	}
+
+	tests := []struct {
+		name       string
+		user       string
+		wantStatus int
+	}{
+		{"another user's alert", "bob", http.StatusNotFound},
+		{"own alert", "alice", http.StatusOK},
+		{"deleted alert", "alice", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := ta.do(t, tt.user, http.MethodDelete, "/alerts/"+created.ID, nil, nil)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: %d %s, want %d", tt.name, rec.Code, rec.Body, tt.wantStatus)
+		}
+	}
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	ta := newTestAPI(t)
+	ta.loadSymbols(t)
+
+	rules := []AlertRequest{
+		{Symbol: "btc", Condition: "above", Threshold: 150},
+		{Symbol: "btc", Condition: "below", Threshold: 50},
+		{Symbol: "btc", Condition: "change", Threshold: 20, Window: "1h"},
+	}
+	ids := make(map[string]string) // rule id -> condition
+	for _, rule := range rules {
+		var created AlertResponse
+		if rec := ta.do(t, "alice", http.MethodPost, "/alerts", rule, &created); rec.Code != http.StatusCreated {
+			t.Fatalf("create %+v: %d %s", rule, rec.Code, rec.Body)
+		}
+		ids[created.ID] = created.Condition
+	}
+
+	// the change rule compares against the oldest sample in its window
+	now := time.Now().UTC()
+	ta.series.Append("bitcoin", domain.PricePoint{Price: 100, Timestamp: now.Add(-30 * time.Minute)})
+
+	events := func(user string) []string {
+		var list struct {
+			Events []AlertEventResponse `json:"events"`
+		}
+		ta.do(t, user, http.MethodGet, "/alerts/events", nil, &list)
+		conditions := make([]string, len(list.Events))
+		for i, event := range list.Events {
+			if ids[event.RuleID] != event.Condition {
+				t.Errorf("event %+v does not match its rule", event)
+			}
+			conditions[i] = event.Condition
+		}
+		return conditions
+	}
+
+	steps := []struct {
+		price float64
+		want  int // events recorded so far
+	}{
+		{price: 100, want: 0},
+		{price: 160, want: 2}, // above and +60%
+		{price: 170, want: 2}, // still above, fires once
+		{price: 40, want: 3},  // below, above cleared
+		{price: 160, want: 5}, // above again, and +60% again
+	}
+	for _, step := range steps {
+		ta.evaluateAlerts(context.Background(), &domain.Coin{ID: "bitcoin", Symbol: "btc", CurrentPrice: step.price})
+		if got := events("alice"); len(got) != step.want {
+			t.Errorf("after %v: events %v, want %d", step.price, got, step.want)
+		}
+	}
+	if got := events("bob"); len(got) != 0 {
+		t.Errorf("bob sees alice's events %v", got)
+	}
+
+	rec := ta.do(t, "alice", http.MethodGet, "/alerts/events?limit=0", nil, nil)
+	if rec.Code != http.StatusBadRequest || problemCode(rec) != "invalid_limit" {
+		t.Errorf("limit=0: %d %s, want 400 invalid_limit", rec.Code, problemCode(rec))
+	}
+}
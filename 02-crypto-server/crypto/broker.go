@@ -0,0 +1,143 @@
+package crypto
+
+import (
+	"cryptoserver/clean/domain"
+	"sync"
+	"time"
+)
+
+// backlogSize is how many ticks are kept for clients resuming a stream.
+const backlogSize = 1024
+
+type Tick struct {
+	Seq         uint64    `json:"seq"`
+	ID          string    `json:"id"`
+	Symbol      string    `json:"symbol"`
+	Name        string    `json:"name"`
+	Price       float64   `json:"price"`
+	LastUpdated string    `json:"last_updated"`
+	At          time.Time `json:"at"`
+}
+
+type subscriber struct {
+	ticks      chan Tick
+	ids        map[string]bool // coins the subscriber follows
+	disconnect bool            // on a full queue: drop the subscriber instead of the tick
+	dropped    int
+	gone       chan struct{} // closed once a disconnecting subscriber fell behind
+}
+
+// broker fans price ticks out to streaming clients. Every tick gets a
+// sequence number, and the latest backlogSize ticks are kept so that a client
+// can resume after a reconnect. A subscriber that does not keep up never
+// slows the collector down: it either loses ticks or gets disconnected,
+// depending on stream.overflow.
+type broker struct {
+	mu      sync.Mutex
+	first   uint64 // sequence numbers start from the startup time, so ids stay increasing across restarts
+	seq     uint64
+	backlog []Tick // ring buffer, backlog[seq % backlogSize]
+	subs    map[*subscriber]struct{}
+}
+
+func newBroker() *broker {
+	start := uint64(time.Now().UnixMilli())
+	return &broker{
+		first:   start,
+		seq:     start,
+		backlog: make([]Tick, backlogSize),
+		subs:    make(map[*subscriber]struct{}),
+	}
+}
+
+func (b *broker) publish(coin *domain.Coin) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	tick := Tick{
+		Seq:         b.seq,
+		ID:          coin.ID,
+		Symbol:      coin.Symbol,
+		Name:        coin.Name,
+		Price:       coin.CurrentPrice,
+		LastUpdated: coin.LastUpdated,
+		At:          time.Now().UTC(),
+	}
+	b.backlog[tick.Seq%backlogSize] = tick
+
+	for sub := range b.subs {
+		if !sub.ids[tick.ID] {
+			continue
+		}
+		select {
+		case sub.ticks <- tick:
+		default:
+			sub.dropped++
+			if sub.disconnect {
+				delete(b.subs, sub)
+				close(sub.gone)
+			}
+		}
+	}
+}
+
+// subscribe registers a subscriber following ids and returns the ticks of
+// those coins published after since that are still in the backlog, oldest
+// first. since 0 is a fresh client, it starts from now.
+func (b *broker) subscribe(size int, disconnect bool, ids map[string]bool, since uint64) (*subscriber, []Tick) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscriber{
+		ticks:      make(chan Tick, size),
+		ids:        ids,
+		disconnect: disconnect,
+		gone:       make(chan struct{}),
+	}
+	b.subs[sub] = struct{}{}
+
+	if since == 0 || since >= b.seq {
+		return sub, nil
+	}
+	var missed []Tick
+	for seq := max(since, b.first, b.seq-min(b.seq, backlogSize)) + 1; seq <= b.seq; seq++ {
+		if tick := b.backlog[seq%backlogSize]; ids[tick.ID] {
+			missed = append(missed, tick)
+		}
+	}
+	return sub, missed
+}
+
+// follow replaces the coins sub follows.
+func (b *broker) follow(sub *subscriber, ids map[string]bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub.ids = ids
+}
+
+// followed returns every coin some subscriber follows, so the collector keeps
+// them fresh.
+func (b *broker) followed() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	seen := make(map[string]bool)
+	ids := make([]string, 0)
+	for sub := range b.subs {
+		for id := range sub.ids {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func (b *broker) unsubscribe(sub *subscriber) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, sub)
+	return sub.dropped
+}
@@ -0,0 +1,105 @@
+package crypto
+
+import (
+	"cryptoserver/clean/domain"
+	"testing"
+)
+
+func TestBrokerResume(t *testing.T) {
+	follow := map[string]bool{"bitcoin": true}
+
+	tests := []struct {
+		name      string
+		published []string
+		since     func(b *broker) uint64
+		want      []uint64 // offsets from first
+	}{
+		{
+			name:      "fresh client starts from now",
+			published: []string{"bitcoin", "bitcoin"},
+			since:     func(b *broker) uint64 { return 0 },
+		},
+		{
+			name:      "replays followed coins after since",
+			published: []string{"bitcoin", "ethereum", "bitcoin", "bitcoin"},
+			since:     func(b *broker) uint64 { return b.first + 1 },
+			want:      []uint64{3, 4},
+		},
+		{
+			name:      "replays everything since startup",
+			published: []string{"bitcoin", "ethereum", "bitcoin"},
+			since:     func(b *broker) uint64 { return b.first },
+			want:      []uint64{1, 3},
+		},
+		{
+			name:      "caught up client gets nothing",
+			published: []string{"bitcoin", "bitcoin"},
+			since:     func(b *broker) uint64 { return b.seq },
+		},
+		{
+			name:      "since from the future gets nothing",
+			published: []string{"bitcoin"},
+			since:     func(b *broker) uint64 { return b.seq + 100 },
+		},
+		{
+			name:      "since before startup replays from startup",
+			published: []string{"bitcoin"},
+			since:     func(b *broker) uint64 { return b.first - 100 },
+			want:      []uint64{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBroker()
+			for _, id := range tt.published {
+				b.publish(&domain.Coin{ID: id})
+			}
+
+			_, missed := b.subscribe(1, false, follow, tt.since(b))
+			if len(missed) != len(tt.want) {
+				t.Fatalf("replayed %d ticks, want %d", len(missed), len(tt.want))
+			}
+			for i, tick := range missed {
+				if tick.Seq != b.first+tt.want[i] || tick.ID != "bitcoin" {
+					t.Errorf("tick %d = %d %s, want %d bitcoin", i, tick.Seq-b.first, tick.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerBacklogOverflow(t *testing.T) {
+	b := newBroker()
+	for range backlogSize + 10 {
+		b.publish(&domain.Coin{ID: "bitcoin"})
+	}
+
+	_, missed := b.subscribe(1, false, map[string]bool{"bitcoin": true}, b.first+1)
+	if len(missed) != backlogSize {
+		t.Fatalf("replayed %d ticks, want %d", len(missed), backlogSize)
+	}
+	if missed[0].Seq != b.seq-backlogSize+1 || missed[len(missed)-1].Seq != b.seq {
+		t.Errorf("replayed %d - %d, want the latest %d ticks", missed[0].Seq, missed[len(missed)-1].Seq, backlogSize)
+	}
+}
+
+func TestBrokerOverflow(t *testing.T) {
+	follow := map[string]bool{"bitcoin": true}
+
+	b := newBroker()
+	dropping, _ := b.subscribe(1, false, follow, 0)
+	disconnecting, _ := b.subscribe(1, true, follow, 0)
+	for range 3 {
+		b.publish(&domain.Coin{ID: "bitcoin"})
+	}
+
+	if dropped := b.unsubscribe(dropping); dropped != 2 {
+		t.Errorf("dropped %d ticks, want 2", dropped)
+	}
+	select {
+	case <-disconnecting.gone:
+	default:
+		t.Error("a disconnecting subscriber that fell behind is still subscribed")
+	}
+}
@@ -0,0 +1,219 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"cryptoserver/errorfmt"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// requestsPerCoin is the number of upstream calls a single refresh costs:
+// one for the coin and one for its history.
+const requestsPerCoin = 2
+
+type CollectorStatus struct {
+	Running      bool      `json:"running"`
+	LastRun      time.Time `json:"last_run"`
+	LastFinished time.Time `json:"last_finished"`
+	LastDuration string    `json:"last_duration"`
+	Coins        int       `json:"coins"`
+	Refreshed    int       `json:"refreshed"`
+	Errors       int       `json:"errors"`
+	LastError    string    `json:"last_error,omitempty"` // error code, the detail is only logged
+	LastErrorAt  time.Time `json:"last_error_at,omitzero"`
+}
+
+// fail counts err against the run. The status is served without auth, so
+// only the error code is kept: the text names upstream URLs and coin ids.
+func (run *CollectorStatus) fail(err error) {
+	run.Errors++
+	run.LastError = apperror.Classify(err).Code
+	run.LastErrorAt = time.Now()
+}
+
+type collectorStatus struct {
+	mu sync.RWMutex
+	CollectorStatus
+}
+
+// BackgroundCaching keeps every watched coin warm in the cache until ctx is
+// cancelled. Upstream calls are paced so that the collector never spends more
+// than collector.requests_per_minute.
+func (api *API) BackgroundCaching(ctx context.Context) {
+	pace := time.Minute / time.Duration(api.collector.RequestsPerMinute)
+	budget := time.NewTicker(pace)
+	defer budget.Stop()
+
+	slog.Info("background caching started", "interval", api.collector.Interval,
+		"requests_per_minute", api.collector.RequestsPerMinute)
+	defer slog.Info("background caching stopped")
+
+	for {
+		api.collect(ctx, budget.C)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(api.collector.Interval):
+		}
+	}
+}
+
+func (api *API) collect(ctx context.Context, budget <-chan time.Time) {
+	start := time.Now()
+	api.status.mu.Lock()
+	api.status.Running = true
+	api.status.mu.Unlock()
+
+	run := CollectorStatus{LastRun: start}
+	defer func() {
+		run.LastFinished = time.Now()
+		run.LastDuration = run.LastFinished.Sub(start).String()
+		slog.Debug("collector run finished", "coins", run.Coins, "refreshed", run.Refreshed,
+			"errors", run.Errors, "duration", run.LastDuration)
+		api.status.mu.Lock()
+		api.status.CollectorStatus = run
+		api.status.mu.Unlock()
+	}()
+
+	watched, err := api.watchedCoins(ctx)
+	if err != nil {
+		slog.Warn("collector listing watched coins failed", "err", err)
+		run.fail(err)
+		return
+	}
+	run.Coins = len(watched)
+
+	for id, keys := range watched {
+		for range requestsPerCoin {
+			select {
+			case <-ctx.Done():
+				return
+			case <-budget:
+			}
+		}
+
+		if err := api.refreshCoin(ctx, id, keys); err != nil {
+			slog.Warn("collector refresh failed", "coin", id, "err", err)
+			run.fail(err)
+			continue
+		}
+		run.Refreshed++
+	}
+}
+
+// watchedCoins maps every coin id in any user's watchlist to the watchlist
+// keys that reference it. Coins with alert rules or followed by a WebSocket
+// client are collected as well, even if nobody watches them.
+func (api *API) watchedCoins(ctx context.Context) (map[string][]string, error) {
+	const keysPerRequest = 100
+	watched := make(map[string][]string)
+	iter := api.cache.Scan(ctx, 0, repoPrefix+"*", keysPerRequest).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		id := key[strings.LastIndex(key, ":")+1:]
+		watched[id] = append(watched[id], key)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	alerted, err := api.alerts.Coins()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range append(alerted, api.ticks.followed()...) {
+		if _, ok := watched[id]; !ok {
+			watched[id] = nil
+		}
+	}
+	return watched, nil
+}
+
+func (api *API) refreshCoin(ctx context.Context, id string, keys []string) error {
+	coin, points, err := api.getCoinAndHistory(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	api.sample(ctx, coin)
+	api.evaluateAlerts(ctx, coin)
+	api.ticks.publish(coin)
+
+	if err := api.storeCoin(ctx, coin); err != nil {
+		return err
+	}
+
+	hr := defaultHistoryRange(time.Now())
+	candlesJSON, err := json.Marshal(buildCandles(points, hr))
+	if err != nil {
+		return err
+	}
+	if err := api.histories.Store(ctx, historyKey(id, domain.DefaultCurrency, hr), candlesJSON); err != nil {
+		return err
+	}
+
+	history := toHistoryObjects(points)
+	for _, key := range keys {
+		api.refreshSnap(ctx, key, coin, history)
+	}
+	return nil
+}
+
+// refreshSnap rewrites a watchlist entry in place, keeping its expiry so the
+// collector never resurrects a watch the user let lapse or deleted.
+func (api *API) refreshSnap(ctx context.Context, key string, coin *domain.Coin, history []HistoryObject) {
+	snapString, err := api.cache.Get(ctx, key).Result()
+	if err != nil {
+		return
+	}
+
+	snap := Snap{}
+	if err := json.Unmarshal([]byte(snapString), &snap); err != nil {
+		return
+	}
+
+	snap.Crypto.Name = coin.Name
+	snap.Crypto.CurrentPrice = coin.CurrentPrice
+	snap.Crypto.LastUpdated = coin.LastUpdated
+	snap.Crypto.History = history
+
+	snapJSON, err := json.Marshal(snap)
+	if err != nil {
+		return
+	}
+
+	err = api.cache.SetArgs(ctx, key, snapJSON, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if err != nil && err != redis.Nil {
+		slog.Error("watchlist refresh failed", "key", key, "err", err)
+	}
+}
+
+func (api *API) CollectorInterval() time.Duration {
+	return api.collector.Interval
+}
+
+func (api *API) Status() CollectorStatus {
+	api.status.mu.RLock()
+	defer api.status.mu.RUnlock()
+	return api.status.CollectorStatus
+}
+
+func (api *API) CollectorStatus(w http.ResponseWriter, r *http.Request) {
+	clientJSON, err := json.Marshal(api.Status())
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(clientJSON)
+}
@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unlimited is a collector budget that never makes it wait.
+func unlimited(calls int) <-chan time.Time {
+	budget := make(chan time.Time, calls)
+	for range calls {
+		budget <- time.Now()
+	}
+	return budget
+}
+
+func TestCollect(t *testing.T) {
+	ta := newTestAPI(t)
+	ctx := context.Background()
+	if rec := ta.do(t, "alice", http.MethodPost, "/crypto", map[string]string{"symbol": "btc"}, nil); rec.Code != http.StatusCreated {
+		t.Fatalf("watch: %d %s", rec.Code, rec.Body)
+	}
+	// a watch on a coin the provider no longer lists
+	ta.cache.Set(ctx, watchKey("bob", "delisted-coin"), "{}", time.Hour)
+
+	ta.collect(ctx, unlimited(4*requestsPerCoin))
+
+	status := ta.Status()
+	if status.Coins != 2 || status.Refreshed != 1 || status.Errors != 1 {
+		t.Errorf("status = %+v, want 2 coins, 1 refreshed, 1 error", status)
+	}
+	if status.LastError != "symbol_not_found" || status.LastErrorAt.IsZero() {
+		t.Errorf("last error = %q at %v, want symbol_not_found with a time", status.LastError, status.LastErrorAt)
+	}
+
+	statusJSON, _ := json.Marshal(status)
+	if strings.Contains(string(statusJSON), "delisted-coin") {
+		t.Errorf("status %s names the failed coin", statusJSON)
+	}
+
+	var quote CoinResponse
+	if rec := ta.do(t, "alice", http.MethodGet, "/crypto/btc?vs=eur", nil, &quote); rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("collected coin: %d %s, want a cache hit", rec.Code, rec.Header().Get("X-Cache"))
+	}
+	if quote.CurrentPrice != 90 {
+		t.Errorf("collected eur price = %v, want 90", quote.CurrentPrice)
+	}
+}
@@ -2,11 +2,16 @@ package crypto
 
 import (
 	"context"
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"cryptoserver/config"
 	"cryptoserver/errorfmt"
+	"cryptoserver/lru"
+	"cryptoserver/metrics"
+	"cryptoserver/security"
+	"cryptoserver/swr"
 	"encoding/json"
-	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -20,56 +25,62 @@ const (
 	repoPrefix         = "repo:"
 	coinCachePrefix    = "coin:"
 	historyCachePrefix = "history:"
-	statsCachePrefix   = "stats:"
 )
 
 var (
-	ErrNoID                 = errors.New("No id by your symbol.")
-	ErrListCrypto           = errors.New("ListCrypto executed wrong.")
-	ErrLimitExceeded        = errors.New("Request limit exceeded.")
-	ErrCryptoAlreadyWatched = errors.New("Crypto has been already watched.")
-	ErrCryptoNotWatched     = errors.New("Crypto doesn't watched yet.")
+	ErrNoID                 = domain.ErrNoID
+	ErrListCrypto           = apperror.New(apperror.Internal, "list_failed", "ListCrypto executed wrong.")
+	ErrLimitExceeded        = domain.ErrLimitExceeded
+	ErrCryptoAlreadyWatched = apperror.New(apperror.Conflict, "already_watched", "Crypto has been already watched.")
+	ErrCryptoNotWatched     = apperror.New(apperror.NotFound, "not_watched", "Crypto doesn't watched yet.")
+	ErrNoUser               = security.ErrNoPrincipal
+	ErrInvalidJSON          = apperror.New(apperror.Validation, "invalid_json", "Invalid json.")
 )
 
 type API struct {
-	rootURL      string
-	key          string
-	ctx          context.Context
-	client       *http.Client
-	cache        *redis.Client
-	recordsCount int
-}
-
-type CryptoDTO struct {
-	Id     string `json:"id"`
-	Symbol string `json:"symbol"`
-	Name   string `json:"name"`
-}
-
-type CryptoDTOList struct {
-	Coins []CryptoDTO `json:"coins"`
-}
-
-type CoinDTO struct {
-	Symbol     string `json:"symbol"`
-	Name       string `json:"name"`
-	MarketData struct {
-		CurrentPrice struct {
-			Usd float64 `json:"usd"`
-		} `json:"current_price"`
-	} `json:"market_data"`
-	LastUpdated string `json:"last_updated"`
+	provider  domain.MarketDataProvider
+	ctx       context.Context
+	cache     *redis.Client
+	ttl       config.Cache
+	series    domain.PriceSeries
+	alerts    domain.AlertStore
+	portfolio domain.PortfolioStore
+	collector config.Collector
+	status    collectorStatus
+	stream    config.Stream
+
+	symbols    *symbolIndex
+	symbolsCfg config.Symbols
+
+	coins     *swr.Cache
+	histories *swr.Cache
+	ids       *lru.Cache[string] // symbol -> id, in front of redis
+
+	ticks       *broker
+	streams     context.Context
+	stopStreams context.CancelFunc
 }
 
 type CoinResponse struct {
 	Symbol       string  `json:"symbol"`
 	Name         string  `json:"name"`
+	Currency     string  `json:"currency"`
 	CurrentPrice float64 `json:"current_price"`
 	LastUpdated  string  `json:"last_updated"`
 }
 
-type HistoryDTO struct {
-	Prices [][]float64 `json:"prices"`
+func newCoinResponse(coin *domain.Coin, currency string) (CoinResponse, error) {
+	price, ok := coin.Price(currency)
+	if !ok {
+		return CoinResponse{}, domain.ErrNoPrice
+	}
+	return CoinResponse{
+		Symbol:       coin.Symbol,
+		Name:         coin.Name,
+		Currency:     currency,
+		CurrentPrice: price,
+		LastUpdated:  coin.LastUpdated,
+	}, nil
 }
 
 type HistoryObject struct {
@@ -77,17 +88,17 @@ type HistoryObject struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// HistoryResponse is one page of candles of a history range.
 type HistoryResponse struct {
-	Symbol  string          `json:"symbol"`
-	History []HistoryObject `json:"history"`
-}
-
-type StatsDTO struct {
-	CurrentPrice             float64 `json:"current_price"`
-	High24h                  float64 `json:"high_24h"`
-	Low24h                   float64 `json:"low_24h"`
-	PriceChange24h           float64 `json:"price_change_24h"`
-	PriceChangePercentage24h float64 `json:"price_change_percentage_24h"`
+	Symbol   string    `json:"symbol"`
+	Currency string    `json:"currency"`
+	Interval string    `json:"interval"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Page     int       `json:"page"`
+	Pages    int       `json:"pages"`
+	NextPage int       `json:"next_page,omitempty"`
+	Candles  []Candle  `json:"candles"`
 }
 
 type Record struct {
@@ -101,118 +112,173 @@ type Record struct {
 
 type StatsResponse struct {
 	Symbol       string  `json:"symbol"`
+	Window       string  `json:"window"`
+	Currency     string  `json:"currency"`
 	CurrentPrice float64 `json:"current_price"`
 	Stats        Record  `json:"stats"`
 }
 
-func NewAPI() *API {
+func NewAPI(provider domain.MarketDataProvider, series domain.PriceSeries, alerts domain.AlertStore,
+	portfolio domain.PortfolioStore, cache *redis.Client, cfg *config.Config) *API {
+	c := cfg.Cache
 	api := &API{
-		rootURL: "https://api.coingecko.com/api/v3",
-		key:     "",
-		ctx:     context.Background(),
-		client: &http.Client{
-			Timeout: 15 * time.Second,
-		},
-		cache: redis.NewClient(&redis.Options{
-			Addr: "localhost:6379",
-		}),
-		recordsCount: 100,
-	}
+		provider:  provider,
+		series:    series,
+		alerts:    alerts,
+		portfolio: portfolio,
+		ctx:       context.Background(),
+		cache:     cache,
+		ttl:       cfg.Cache,
+		collector: cfg.Collector,
+		stream:    cfg.Stream,
+		ticks:     newBroker(),
+
+		symbols:    newSymbolIndex(cfg.Symbols.Pinned),
+		symbolsCfg: cfg.Symbols,
+
+		coins:     swr.New(cache, coinCachePrefix, c.CoinTTL, c.CoinHardTTL, c.LocalBytes, c.LocalTTL),
+		histories: swr.New(cache, historyCachePrefix, c.CoinTTL, c.CoinHardTTL, c.LocalBytes, c.LocalTTL),
+		ids:       lru.New[string](c.LocalSize, c.LocalTTL, nil),
+	}
+	api.streams, api.stopStreams = context.WithCancel(context.Background())
 
 	_, err := api.cache.Ping(api.ctx).Result()
 	if err != nil {
-		log.Println("Cannot connect to redis.", err)
-		return nil
+		// the client reconnects on its own, /readyz reports the outage meanwhile
+		slog.Warn("cannot connect to redis, starting degraded", "err", err)
+		return api
 	}
 
-	iter := api.cache.Scan(api.ctx, 0, "*", 10).Iterator()
-
-	for iter.Next(api.ctx) {
-		fmt.Println("Found Hash key:", iter.Val())
+	if slog.Default().Enabled(api.ctx, slog.LevelDebug) {
+		iter := api.cache.Scan(api.ctx, 0, "*", 10).Iterator()
+		for iter.Next(api.ctx) {
+			slog.Debug("found key", "key", iter.Val())
+		}
 	}
 
 	//api.cache.FlushDB(api.ctx)
-	log.Println("Successful connection to redis.")
+	slog.Info("connected to redis")
 	return api
 }
 
-func (api *API) BackgroundCaching() {
-	const requestLimit = 15
-	const timeout = 60
-}
-
-func (api *API) cacheCryptoID(crypto CryptoDTO) {
-	err := api.cache.SetNX(api.ctx, crypto.Symbol, crypto.Id, 30*time.Minute).Err()
+func (api *API) cacheCryptoID(ctx context.Context, symbol, id string) {
+	api.ids.Set(symbol, id)
+	err := api.cache.SetNX(ctx, symbol, id, api.ttl.IDTTL).Err()
 	if err != nil {
-		log.Println(err.Error())
+		slog.ErrorContext(ctx, "caching coin id failed", "symbol", symbol, "err", err)
 	}
 }
 
-func (api *API) cacheCryptoIDSet(cryptos []CryptoDTO) {
-	for _, crypto := range cryptos {
-		api.cacheCryptoID(crypto)
+// getID resolves a symbol or a canonical coin id through the symbol index,
+// or through the provider search while the index is not loaded yet.
+func (api *API) getID(ctx context.Context, symbol string) (string, error) {
+	if id, ok, err := api.symbols.resolve(symbol); ok {
+		return id, err
+	}
+
+	if id, ok := api.ids.Get(symbol); ok {
+		return id, nil
 	}
-}
 
-func (api *API) getID(symbol string) (string, error) {
-	id, err := api.cache.Get(api.ctx, symbol).Result()
+	id, err := api.cache.Get(ctx, symbol).Result()
+	metrics.ObserveCache("symbol", err == nil)
 	if err == nil {
-		fmt.Println("cache boom")
+		api.ids.Set(symbol, id)
 		return id, nil
 	}
 
 	// CACHE MISS
-	url := fmt.Sprintf("%s/search?query=%s", api.rootURL, symbol)
-	req, err := http.NewRequest("GET", url, nil)
+	id, err = api.provider.SearchSymbol(ctx, symbol)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Add("x-cg-demo-api-key", api.key)
 
-	resp, err := api.client.Do(req)
-	if err != nil {
-		return "", err
+	api.cacheCryptoID(ctx, symbol, id)
+	return id, nil
+}
+
+func toHistoryObjects(points []domain.PricePoint) []HistoryObject {
+	history := make([]HistoryObject, len(points))
+	for i, point := range points {
+		history[i] = HistoryObject{
+			Price:     point.Price,
+			Timestamp: point.Timestamp,
+		}
 	}
+	return history
+}
 
-	cryptos := CryptoDTOList{}
-	if err := json.NewDecoder(resp.Body).Decode(&cryptos); err != nil {
-		return "", err
+// coinKey and historyKey scope cached responses to their quote currency,
+// history also to its normalized range.
+func coinKey(id, currency string) string {
+	return coinCachePrefix + id + ":" + currency
+}
+
+func historyKey(id, currency string, hr historyRange) string {
+	return historyCachePrefix + id + ":" + currency + ":" + hr.key()
+}
+
+// seriesID names the price samples of a coin in currency. Samples in
+// DefaultCurrency keep the bare coin id they were stored under before.
+func seriesID(id, currency string) string {
+	if currency == domain.DefaultCurrency {
+		return id
 	}
+	return id + ":" + currency
+}
 
-	for _, crypto := range cryptos.Coins {
-		if strings.EqualFold(crypto.Symbol, symbol) {
-			id := crypto.Id
-			return id, nil
+// quoteCurrency is ?vs= if given, the caller's preferred currency otherwise.
+func quoteCurrency(r *http.Request) (string, error) {
+	currency := strings.ToLower(r.URL.Query().Get("vs"))
+	if currency == "" {
+		currency = domain.DefaultCurrency
+		if principal, ok := security.CurrentUser(r.Context()); ok && principal.Currency != "" {
+			currency = principal.Currency
 		}
 	}
+	if !domain.Currencies[currency] {
+		return "", domain.ErrInvalidCurrency
+	}
+	return currency, nil
+}
 
-	return "", ErrNoID
+// watchKey scopes a watched coin to its owner: repo:<user id>:<coin id>.
+func watchKey(userID, id string) string {
+	return repoPrefix + userID + ":" + id
 }
 
-func (api *API) sendCryptoRequest(url string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+func currentPrincipal(w http.ResponseWriter, r *http.Request) (*security.Principal, bool) {
+	principal, ok := security.CurrentUser(r.Context())
+	if !ok {
+		errorfmt.Write(w, r, ErrNoUser)
+		return nil, false
 	}
+	return principal, true
+}
 
-	req.Header.Add("x-cg-demo-api-key", api.key)
-	resp, err := api.client.Do(req)
-	if err != nil {
-		return nil, err
+func currentUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	principal, ok := currentPrincipal(w, r)
+	if !ok {
+		return "", false
 	}
-
-	return resp, nil
+	return principal.UserID, true
 }
 
 func (api *API) ListCryptos(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
 	const keysPerRequest = 10
-	iter := api.cache.Scan(api.ctx, 0, repoPrefix+"*", keysPerRequest).Iterator()
-	cryptos := make([]WatchAttributes, 1)
-	for iter.Next(api.ctx) {
+	iter := api.cache.Scan(r.Context(), 0, watchKey(userID, "*"), keysPerRequest).Iterator()
+	cryptos := make([]WatchAttributes, 0)
+	for iter.Next(r.Context()) {
 		key := iter.Val()
-		snapString, err := api.cache.Get(api.ctx, key).Result()
+		snapString, err := api.cache.Get(r.Context(), key).Result()
+		metrics.ObserveCache(repoPrefix, err == nil)
 		if err != nil {
 			continue
 		}
@@ -229,7 +295,7 @@ func (api *API) ListCryptos(w http.ResponseWriter, r *http.Request) {
 
 	clientJSON, err := json.Marshal(snaps)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadGateway)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
@@ -241,206 +307,260 @@ func (api *API) GetCrypto(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	symbol := chi.URLParam(r, "symbol")
-	id, err := api.getID(symbol)
+	id, err := api.getID(r.Context(), symbol)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusNotFound)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	key := coinCachePrefix + id
-	cachedJSON, err := api.cache.Get(api.ctx, key).Result()
-	if cachedJSON != "" && err == nil {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(cachedJSON))
+	currency, err := quoteCurrency(r)
+	if err != nil {
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	url := fmt.Sprintf("%s/coins/%s", api.rootURL, id)
-	resp, err := api.sendCryptoRequest(url)
+	cached, err := api.coinJSON(r.Context(), id, currency)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusNotFound)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	defer resp.Body.Close()
+	cached.WriteHeaders(w)
+	w.WriteHeader(http.StatusOK)
+	w.Write(cached.Value)
+}
 
-	coin := CoinDTO{}
-	if err := json.NewDecoder(resp.Body).Decode(&coin); err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusNotFound)
-		return
-	}
+// coinJSON returns the CoinResponse of id in currency, cached or fetched.
+func (api *API) coinJSON(ctx context.Context, id, currency string) (swr.Result, error) {
+	return api.coins.Get(ctx, coinKey(id, currency), func(ctx context.Context) ([]byte, error) {
+		coin, err := api.provider.GetCoin(ctx, id)
+		if err != nil {
+			return nil, err
+		}
 
-	if coin.Symbol == "" && coin.Name == "" {
-		http.Error(w, errorfmt.Jsonize(ErrLimitExceeded), http.StatusNotFound)
-		return
-	}
+		api.sample(ctx, coin)
+		response, err := newCoinResponse(coin, currency)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(response)
+	})
+}
 
-	formatedCoin := CoinResponse{
-		Symbol:       coin.Symbol,
-		Name:         coin.Name,
-		CurrentPrice: coin.MarketData.CurrentPrice.Usd,
-		LastUpdated:  coin.LastUpdated,
+// storeCoin caches coin as fresh in every currency it is priced in.
+func (api *API) storeCoin(ctx context.Context, coin *domain.Coin) error {
+	for currency := range coin.Prices {
+		response, err := newCoinResponse(coin, currency)
+		if err != nil {
+			return err
+		}
+		coinJSON, err := json.Marshal(response)
+		if err != nil {
+			return err
+		}
+		if err := api.coins.Store(ctx, coinKey(coin.ID, currency), coinJSON); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	clientJSON, err := json.Marshal(formatedCoin)
-	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusNotFound)
-		return
+// evictCoin drops id from the in-process cache of every instance, so they
+// serve what is in redis now.
+func (api *API) evictCoin(ctx context.Context, id string) {
+	keys := make([]string, 0, len(domain.Currencies))
+	for currency := range domain.Currencies {
+		keys = append(keys, coinKey(id, currency))
 	}
+	if err := api.coins.Invalidate(ctx, keys...); err != nil {
+		slog.ErrorContext(ctx, "evicting coin failed", "coin", id, "err", err)
+	}
+}
 
-	api.cache.SetNX(api.ctx, key, clientJSON, 15*time.Minute)
+// ListenInvalidations applies coin evictions of other instances until ctx is
+// cancelled.
+func (api *API) ListenInvalidations(ctx context.Context) {
+	api.coins.Listen(ctx)
+}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(clientJSON)
+// SweepLocalCaches drops expired in-process entries every cache.local_ttl
+// until ctx is cancelled, so entries nobody asks for again do not pile up.
+func (api *API) SweepLocalCaches(ctx context.Context) {
+	ticker := time.NewTicker(api.ttl.LocalTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			api.coins.Sweep()
+			api.histories.Sweep()
+			api.ids.Sweep()
+		}
+	}
 }
 
 func (api *API) GetHistory(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	symbol := chi.URLParam(r, "symbol")
-	id, err := api.getID(symbol)
+	id, err := api.getID(r.Context(), symbol)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusNotFound)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	key := historyCachePrefix + id
-	cachedJSON, err := api.cache.Get(api.ctx, key).Result()
-	if cachedJSON != "" && err == nil {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(cachedJSON))
+	currency, err := quoteCurrency(r)
+	if err != nil {
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	url := fmt.Sprintf("%s/coins/%s/market_chart?vs_currency=usd&days=1", api.rootURL, id)
-	resp, err := api.sendCryptoRequest(url)
+	hr, err := parseHistoryRange(r.URL.Query(), time.Now())
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	defer resp.Body.Close()
+	page, limit, err := parsePage(r.URL.Query())
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
 
-	history := HistoryDTO{}
-	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+	candles, cached, err := api.getCandles(r.Context(), id, currency, hr)
+	if err != nil {
+		errorfmt.Write(w, r, err)
 		return
 	}
 
 	formatedHistory := HistoryResponse{
-		Symbol:  symbol,
-		History: make([]HistoryObject, len(history.Prices)),
+		Symbol:   symbol,
+		Currency: currency,
+		Interval: hr.Interval,
+		From:     hr.From,
+		To:       hr.To,
+		Page:     page,
+		Pages:    max(1, (len(candles)+limit-1)/limit),
+		Candles:  []Candle{},
 	}
-
-	for i, price := range history.Prices {
-		valPrice, ms := price[1], int64(price[0])
-		formatedHistory.History[i] = HistoryObject{
-			Price:     valPrice,
-			Timestamp: time.UnixMilli(ms).UTC(),
-		}
+	if page <= formatedHistory.Pages {
+		start := (page - 1) * limit
+		formatedHistory.Candles = candles[start:min(len(candles), start+limit)]
+	}
+	if page < formatedHistory.Pages {
+		formatedHistory.NextPage = page + 1
 	}
 
 	clientJSON, err := json.Marshal(formatedHistory)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	api.cache.SetNX(api.ctx, key, clientJSON, 15*time.Minute)
-
+	cached.WriteHeaders(w)
 	w.WriteHeader(http.StatusOK)
 	w.Write(clientJSON)
 }
 
-func (api *API) countAvgPrice(id string) float64 {
-	url := fmt.Sprintf("%s/coins/%s/market_chart?vs_currency=usd&days=1", api.rootURL, id)
-	resp, err := api.sendCryptoRequest(url)
+// getCandles returns every candle of hr, pages are cut from the cached range.
+func (api *API) getCandles(ctx context.Context, id, currency string, hr historyRange) ([]Candle, swr.Result, error) {
+	cached, err := api.histories.Get(ctx, historyKey(id, currency, hr), func(ctx context.Context) ([]byte, error) {
+		history, err := api.provider.GetHistory(ctx, id, currency, hr.From, hr.To)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(buildCandles(history, hr))
+	})
 	if err != nil {
-		return 0.0
+		return nil, cached, err
 	}
 
-	defer resp.Body.Close()
-
-	history := HistoryDTO{}
-	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
-		return 0.0
+	candles := make([]Candle, 0)
+	if err := json.Unmarshal(cached.Value, &candles); err != nil {
+		return nil, cached, err
 	}
+	return candles, cached, nil
+}
 
-	avg := 0.0
-	for _, priceObject := range history.Prices {
-		price := priceObject[1]
-		avg += price
+func (api *API) sample(ctx context.Context, coin *domain.Coin) {
+	now := time.Now().UTC()
+	prices := coin.Prices
+	if len(prices) == 0 {
+		prices = map[string]float64{domain.DefaultCurrency: coin.CurrentPrice}
 	}
-	if len(history.Prices) == 0 {
-		return 0.0
+	for currency, price := range prices {
+		point := domain.PricePoint{Price: price, Timestamp: now}
+		if err := api.series.Append(seriesID(coin.ID, currency), point); err != nil {
+			slog.ErrorContext(ctx, "storing price sample failed", "coin", coin.ID, "currency", currency, "err", err)
+		}
 	}
-	avg /= float64(len(history.Prices))
-
-	return avg
 }
 
 func (api *API) GetStats(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	symbol := chi.URLParam(r, "symbol")
-	id, err := api.getID(symbol)
+	window := r.URL.Query().Get("window")
+	span, err := parseWindow(window)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusNotFound)
+		errorfmt.Write(w, r, err)
 		return
 	}
+	if window == "" {
+		window = defaultWindow
+	}
 
-	key := statsCachePrefix + id
-	cachedJSON, err := api.cache.Get(api.ctx, key).Result()
-	if cachedJSON != "" && err == nil {
-		log.Println("stats cached hitted")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(cachedJSON))
+	symbol := chi.URLParam(r, "symbol")
+	id, err := api.getID(r.Context(), symbol)
+	if err != nil {
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	url := fmt.Sprintf("%s/coins/markets?vs_currency=usd&ids=%s&symbols=%s", api.rootURL, id, symbol)
-	resp, err := api.sendCryptoRequest(url)
+	currency, err := quoteCurrency(r)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println(resp.Body)
-		http.Error(w, errorfmt.Jsonize(ErrLimitExceeded), resp.StatusCode)
+	now := time.Now()
+	samples, err := api.series.Range(seriesID(id, currency), now.Add(-span), now)
+	if err != nil {
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	statsList := make([]StatsDTO, 1)
-	if err := json.NewDecoder(resp.Body).Decode(&statsList); err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
-		return
+	if len(samples) == 0 {
+		// nothing collected yet, take the first sample ourselves
+		coin, err := api.provider.GetCoin(r.Context(), id)
+		if err != nil {
+			errorfmt.Write(w, r, err)
+			return
+		}
+		api.sample(r.Context(), coin)
+		price, ok := coin.Price(currency)
+		if !ok {
+			errorfmt.Write(w, r, domain.ErrNoPrice)
+			return
+		}
+		samples = []domain.PricePoint{{Price: price, Timestamp: now}}
 	}
 
-	stats := statsList[0]
 	formatedStats := StatsResponse{
 		Symbol:       symbol,
-		CurrentPrice: stats.CurrentPrice,
-		Stats: Record{
-			MinPrice:           stats.Low24h,
-			MaxPrice:           stats.High24h,
-			AvgPrice:           api.countAvgPrice(id),
-			PriceChange:        stats.PriceChange24h,
-			PriceChangePercent: stats.PriceChangePercentage24h,
-			RecordsCount:       api.recordsCount,
-		},
+		Window:       window,
+		Currency:     currency,
+		CurrentPrice: samples[len(samples)-1].Price,
+		Stats:        computeRecord(samples),
 	}
 
 	clientJSON, err := json.Marshal(formatedStats)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadGateway)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	api.cache.SetNX(api.ctx, key, clientJSON, 15*time.Minute)
-
 	w.WriteHeader(http.StatusOK)
 	w.Write(clientJSON)
 }
@@ -464,35 +584,44 @@ type Snaps struct {
 func (api *API) WatchCrypto(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
 	var body struct {
 		Symbol string `json:"symbol"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+		errorfmt.Write(w, r, ErrInvalidJSON)
 		return
 	}
 	symbol := body.Symbol
 
-	id, err := api.getID(symbol)
+	id, err := api.getID(r.Context(), symbol)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusNotFound)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
 	//if _, exists := api.attributes[id]; exists {
-	//	http.Error(w, errorfmt.Jsonize(ErrCryptoAlreadyWatched), http.StatusConflict)
+	//	errorfmt.Write(w, r, ErrCryptoAlreadyWatched)
 	//	return
 	//}
 
-	coin, history, err := api.getCoinAndHistory(id)
+	coin, points, err := api.getCoinAndHistory(r.Context(), id)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
 
 	snap := Snap{
 		Crypto: WatchAttributes{
 			Symbol:       symbol,
 			Name:         coin.Name,
-			CurrentPrice: coin.MarketData.CurrentPrice.Usd,
+			CurrentPrice: coin.CurrentPrice,
 			LastUpdated:  coin.LastUpdated,
-			History:      history,
+			History:      toHistoryObjects(points),
 		},
 	}
 
@@ -501,17 +630,17 @@ func (api *API) WatchCrypto(w http.ResponseWriter, r *http.Request) {
 
 	clientJSON, err := json.Marshal(snap)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	key := repoPrefix + id
-	set, err := api.cache.SetNX(api.ctx, key, clientJSON, 15*time.Minute).Result()
+	key := watchKey(userID, id)
+	set, err := api.cache.SetNX(r.Context(), key, clientJSON, api.ttl.WatchTTL).Result()
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+		errorfmt.Write(w, r, err)
 		return
 	} else if !set {
-		http.Error(w, errorfmt.Jsonize(ErrCryptoAlreadyWatched), http.StatusConflict)
+		errorfmt.Write(w, r, ErrCryptoAlreadyWatched)
 		return
 	}
 
@@ -519,57 +648,27 @@ func (api *API) WatchCrypto(w http.ResponseWriter, r *http.Request) {
 	w.Write(clientJSON)
 }
 
-func (api *API) getCoinAndHistory(id string) (CoinDTO, []HistoryObject, error) {
-	g, _ := errgroup.WithContext(api.ctx)
+// getCoinAndHistory fetches a coin with its last day of prices.
+func (api *API) getCoinAndHistory(ctx context.Context, id string) (*domain.Coin, []domain.PricePoint, error) {
+	g, ctx := errgroup.WithContext(ctx)
 
-	coinChan := make(chan CoinDTO, 1)
+	var coin *domain.Coin
 	g.Go(func() error {
-		defer close(coinChan)
-		url := fmt.Sprintf("%s/coins/%s", api.rootURL, id)
-		resp, err := api.sendCryptoRequest(url)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		coin := CoinDTO{}
-		if err := json.NewDecoder(resp.Body).Decode(&coin); err != nil {
-			return err
-		}
-		if coin.Symbol == "" && coin.Name == "" {
-			return err
-		}
-		coinChan <- coin
-		return nil
+		var err error
+		coin, err = api.provider.GetCoin(ctx, id)
+		return err
 	})
 
-	historyChan := make(chan []HistoryObject, 1)
+	var history []domain.PricePoint
 	g.Go(func() error {
-		defer close(historyChan)
-		url := fmt.Sprintf("%s/coins/%s/market_chart?vs_currency=usd&days=1", api.rootURL, id)
-		resp, err := api.sendCryptoRequest(url)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		history := HistoryDTO{}
-		if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
-			return err
-		}
-		newHistory := make([]HistoryObject, len(history.Prices))
-		for i, obj := range history.Prices {
-			price, ms := obj[1], int64(obj[0])
-			newHistory[i].Price = price
-			newHistory[i].Timestamp = time.UnixMilli(ms).UTC()
-		}
-		historyChan <- newHistory
-		return nil
+		var err error
+		now := time.Now()
+		history, err = api.provider.GetHistory(ctx, id, domain.DefaultCurrency, now.Add(-24*time.Hour), now)
+		return err
 	})
 
-	coin := <-coinChan
-	history := <-historyChan
-
 	if err := g.Wait(); err != nil {
-		return coin, history, err
+		return nil, nil, err
 	}
 
 	return coin, history, nil
@@ -578,16 +677,22 @@ func (api *API) getCoinAndHistory(id string) (CoinDTO, []HistoryObject, error) {
 func (api *API) RefreshCrypto(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
 	symbol := chi.URLParam(r, "symbol")
 
-	id, err := api.getID(symbol)
+	id, err := api.getID(r.Context(), symbol)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusNotFound)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	coin, history, err := api.getCoinAndHistory(id)
-	if coin.Symbol == "" && coin.Name == "" || err != nil {
+	coin, points, err := api.getCoinAndHistory(r.Context(), id)
+	if err != nil {
+		errorfmt.Write(w, r, err)
 		return
 	}
 
@@ -595,50 +700,63 @@ func (api *API) RefreshCrypto(w http.ResponseWriter, r *http.Request) {
 		Crypto: WatchAttributes{
 			Symbol:       symbol,
 			Name:         coin.Name,
-			CurrentPrice: coin.MarketData.CurrentPrice.Usd,
+			CurrentPrice: coin.CurrentPrice,
 			LastUpdated:  coin.LastUpdated,
-			History:      history,
+			History:      toHistoryObjects(points),
 		},
 	}
 
 	clientJSON, err := json.Marshal(snap)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	key := repoPrefix + id
-	if err := api.cache.Set(api.ctx, key, clientJSON, 15*time.Minute).Err(); err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+	key := watchKey(userID, id)
+	if err := api.cache.Set(r.Context(), key, clientJSON, api.ttl.WatchTTL).Err(); err != nil {
+		errorfmt.Write(w, r, err)
 		return
 	}
+	if err := api.storeCoin(r.Context(), coin); err != nil {
+		slog.ErrorContext(r.Context(), "caching refreshed coin failed", "coin", id, "err", err)
+	}
+	api.evictCoin(r.Context(), id)
+	api.ticks.publish(coin)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(clientJSON)
 }
 
 func (api *API) DeleteCrypto(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
 	symbol := chi.URLParam(r, "symbol")
-	id, err := api.getID(symbol)
+	id, err := api.getID(r.Context(), symbol)
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusNotFound)
+		errorfmt.Write(w, r, err)
 		return
 	}
 
-	key := repoPrefix + id
-	cnt, err := api.cache.Exists(api.ctx, key).Result()
+	key := watchKey(userID, id)
+	cnt, err := api.cache.Exists(r.Context(), key).Result()
 	if err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+		errorfmt.Write(w, r, err)
 		return
-	} else if cnt == 0 {
-		http.Error(w, errorfmt.Jsonize(ErrCryptoNotWatched), http.StatusBadRequest)
+	}
+	metrics.ObserveCache(repoPrefix, cnt > 0)
+	if cnt == 0 {
+		errorfmt.Write(w, r, ErrCryptoNotWatched)
 		return
 	}
 
-	if err := api.cache.Del(api.ctx, key).Err(); err != nil {
-		http.Error(w, errorfmt.Jsonize(err), http.StatusBadRequest)
+	if err := api.cache.Del(r.Context(), key).Err(); err != nil {
+		errorfmt.Write(w, r, err)
 		return
 	}
+	api.evictCoin(r.Context(), id)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("{}"))
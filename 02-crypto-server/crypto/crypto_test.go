@@ -0,0 +1,286 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"cryptoserver/clean/domain"
+	"cryptoserver/config"
+	"cryptoserver/provider"
+	"cryptoserver/repository"
+	"cryptoserver/security"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// testCoins are served by the fake provider of newTestAPI.
+var testCoins = []domain.Coin{
+	{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", CurrentPrice: 100, Prices: map[string]float64{"usd": 100, "eur": 90}},
+	{ID: "ethereum", Symbol: "eth", Name: "Ethereum", CurrentPrice: 10, Prices: map[string]float64{"usd": 10, "eur": 9}},
+	{ID: "solana", Symbol: "sol", Name: "Solana", CurrentPrice: 5, Prices: map[string]float64{"usd": 5}},
+	{ID: "solana-wormhole", Symbol: "sol", Name: "Solana (Wormhole)", CurrentPrice: 4, Prices: map[string]float64{"usd": 4}},
+}
+
+// countingProvider counts the upstream calls made through it by method.
+// Like a real provider, a call fails once its context is done.
+type countingProvider struct {
+	*provider.Fake
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (p *countingProvider) count(ctx context.Context, method string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls[method]++
+	if ctx.Err() != nil {
+		p.calls["cancelled"]++
+	}
+	return ctx.Err()
+}
+
+func (p *countingProvider) Calls(method string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls[method]
+}
+
+func (p *countingProvider) SearchSymbol(ctx context.Context, symbol string) (string, error) {
+	if err := p.count(ctx, "SearchSymbol"); err != nil {
+		return "", err
+	}
+	return p.Fake.SearchSymbol(ctx, symbol)
+}
+
+func (p *countingProvider) ListCoins(ctx context.Context) ([]domain.CoinListing, error) {
+	if err := p.count(ctx, "ListCoins"); err != nil {
+		return nil, err
+	}
+	return p.Fake.ListCoins(ctx)
+}
+
+func (p *countingProvider) GetCoin(ctx context.Context, id string) (*domain.Coin, error) {
+	if err := p.count(ctx, "GetCoin"); err != nil {
+		return nil, err
+	}
+	return p.Fake.GetCoin(ctx, id)
+}
+
+func (p *countingProvider) GetQuotes(ctx context.Context, ids []string, currency string) ([]domain.Coin, error) {
+	if err := p.count(ctx, "GetQuotes"); err != nil {
+		return nil, err
+	}
+	return p.Fake.GetQuotes(ctx, ids, currency)
+}
+
+func (p *countingProvider) GetHistory(ctx context.Context, id, currency string, from, to time.Time) ([]domain.PricePoint, error) {
+	if err := p.count(ctx, "GetHistory"); err != nil {
+		return nil, err
+	}
+	return p.Fake.GetHistory(ctx, id, currency, from, to)
+}
+
+type testAPI struct {
+	*API
+	redis    *miniredis.Miniredis
+	provider *countingProvider
+	handler  http.Handler
+}
+
+// newTestAPI serves testCoins from a counting fake provider over miniredis. Requests
+// are made as the user named in the X-User header, with an access token that
+// expires in an hour or after X-Expires-In.
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cache := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { cache.Close() })
+
+	fake := provider.NewFake()
+	now := time.Now()
+	for _, coin := range testCoins {
+		fake.Add(coin, []domain.PricePoint{
+			{Price: coin.CurrentPrice * 0.9, Timestamp: now.Add(-2 * time.Hour)},
+			{Price: coin.CurrentPrice, Timestamp: now.Add(-time.Hour)},
+		})
+	}
+
+	upstream := &countingProvider{Fake: fake, calls: make(map[string]int)}
+	cfg := config.Default()
+	cfg.Symbols.Pinned = map[string]string{}
+	api := NewAPI(upstream, repository.NewRedisSeries(cache, cfg.Series.Retention), repository.NewRedisAlerts(cache),
+		repository.NewRedisPortfolio(cache), cache, cfg)
+	t.Cleanup(api.StopStreams)
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user := r.Header.Get("X-User"); user != "" {
+				expiresIn, err := time.ParseDuration(r.Header.Get("X-Expires-In"))
+				if err != nil {
+					expiresIn = time.Hour
+				}
+				principal := &security.Principal{UserID: user, Currency: domain.DefaultCurrency, ExpiresAt: time.Now().Add(expiresIn)}
+				r = r.WithContext(security.WithPrincipal(r.Context(), principal))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/crypto", api.ListCryptos)
+	r.Post("/crypto", api.WatchCrypto)
+	r.Get("/crypto/search", api.SearchCryptos)
+	r.Get("/crypto/quotes", api.GetQuotes)
+	r.Post("/crypto/quotes", api.PostQuotes)
+	r.Get("/crypto/{symbol}", api.GetCrypto)
+	r.Put("/crypto/{symbol}/refresh", api.RefreshCrypto)
+	r.Get("/crypto/{symbol}/history", api.GetHistory)
+	r.Get("/crypto/{symbol}/stats", api.GetStats)
+	r.Delete("/crypto/{symbol}", api.DeleteCrypto)
+	r.Get("/alerts", api.ListAlerts)
+	r.Post("/alerts", api.CreateAlert)
+	r.Get("/alerts/events", api.ListAlertEvents)
+	r.Delete("/alerts/{id}", api.DeleteAlert)
+	r.Get("/portfolio", api.GetPortfolio)
+	r.Post("/portfolio/transactions", api.AddTransaction)
+	r.Delete("/portfolio/transactions/{id}", api.DeleteTransaction)
+	r.Get("/ws", api.Ticks)
+
+	return &testAPI{API: api, redis: mr, provider: upstream, handler: r}
+}
+
+// loadSymbols loads the symbol index from the fake provider.
+func (ta *testAPI) loadSymbols(t *testing.T) {
+	t.Helper()
+	if err := ta.refreshSymbols(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// do sends body as JSON on behalf of user and decodes the response into out
+// when it is not nil. It returns the recorded response.
+func (ta *testAPI) do(t *testing.T, user, method, path string, body, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	rec := httptest.NewRecorder()
+	ta.handler.ServeHTTP(rec, req)
+
+	if out != nil && rec.Code < 400 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+// problemCode is the code of a problem+json response.
+func problemCode(rec *httptest.ResponseRecorder) string {
+	var problem struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &problem)
+	return problem.Code
+}
+
+func TestRequestContext(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{http.MethodPost, "/crypto", map[string]string{"symbol": "btc"}},
+		{http.MethodPut, "/crypto/btc/refresh", nil},
+		{http.MethodGet, "/crypto/btc/stats", nil},
+	}
+
+	for _, tt := range tests {
+		ta := newTestAPI(t)
+		ta.loadSymbols(t)
+
+		var payload bytes.Buffer
+		json.NewEncoder(&payload).Encode(tt.body)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel() // the client went away
+		req := httptest.NewRequestWithContext(ctx, tt.method, tt.path, &payload)
+		req.Header.Set("X-User", "alice")
+		ta.handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if ta.provider.Calls("cancelled") == 0 {
+			t.Errorf("%s %s: upstream calls did not get the request context", tt.method, tt.path)
+		}
+	}
+}
+
+func TestWatchlistIsPerUser(t *testing.T) {
+	ta := newTestAPI(t)
+	ta.loadSymbols(t)
+
+	tests := []struct {
+		name       string
+		user       string
+		method     string
+		path       string
+		body       any
+		wantStatus int
+		wantCode   string
+	}{
+		{"watch", "alice", http.MethodPost, "/crypto", map[string]string{"symbol": "btc"}, http.StatusCreated, ""},
+		{"watch twice", "alice", http.MethodPost, "/crypto", map[string]string{"symbol": "bitcoin"}, http.StatusConflict, "already_watched"},
+		{"delete another user's coin", "bob", http.MethodDelete, "/crypto/btc", nil, http.StatusNotFound, "not_watched"},
+		{"same coin for another user", "bob", http.MethodPost, "/crypto", map[string]string{"symbol": "eth"}, http.StatusCreated, ""},
+		{"anonymous", "", http.MethodGet, "/crypto", nil, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		rec := ta.do(t, tt.user, tt.method, tt.path, tt.body, nil)
+		if rec.Code != tt.wantStatus || (tt.wantCode != "" && problemCode(rec) != tt.wantCode) {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, rec.Code, problemCode(rec), tt.wantStatus, tt.wantCode)
+		}
+	}
+
+	watched := func(user string) []string {
+		var snaps Snaps
+		ta.do(t, user, http.MethodGet, "/crypto", nil, &snaps)
+		names := make([]string, len(snaps.Cryptos))
+		for i, crypto := range snaps.Cryptos {
+			names[i] = crypto.Name
+		}
+		return names
+	}
+	if got := watched("alice"); len(got) != 1 || got[0] != "Bitcoin" {
+		t.Errorf("alice watches %v, want [Bitcoin]", got)
+	}
+	if got := watched("bob"); len(got) != 1 || got[0] != "Ethereum" {
+		t.Errorf("bob watches %v, want [Ethereum]", got)
+	}
+	if got := watched("carol"); len(got) != 0 {
+		t.Errorf("carol watches %v, want nothing", got)
+	}
+
+	if rec := ta.do(t, "alice", http.MethodDelete, "/crypto/btc", nil, nil); rec.Code != http.StatusOK {
+		t.Errorf("alice deleting a watched coin: %d %s", rec.Code, rec.Body)
+	}
+	if got := watched("alice"); len(got) != 0 {
+		t.Errorf("alice still watches %v after deleting", got)
+	}
+}
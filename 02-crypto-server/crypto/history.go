@@ -0,0 +1,186 @@
+package crypto
+
+import (
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidInterval = apperror.New(apperror.Validation, "invalid_interval", "Interval must be one of 5m, 1h, 1d.")
+	ErrInvalidTime     = apperror.New(apperror.Validation, "invalid_time", "From and to must be RFC 3339 timestamps or unix seconds.")
+	ErrInvalidRange    = apperror.New(apperror.Validation, "invalid_range", "From must be before to and the range at most 1 day for 5m, 90 days for 1h and 365 days for 1d candles.")
+	ErrInvalidPage     = apperror.New(apperror.Validation, "invalid_page", "Page must be a positive number.")
+	ErrInvalidPageSize = apperror.New(apperror.Validation, "invalid_page_size", "Limit must be between 1 and 1000.")
+)
+
+const (
+	defaultInterval = "5m"
+	defaultPageSize = 500
+	maxPageSize     = 1000
+)
+
+// intervals maps a candle interval to its length, to the range returned when
+// the request has no from and to the longest range allowed. The provider
+// returns 5 minute points up to a day and hourly ones up to 90 days, longer
+// ranges would leave most candles of the finer intervals empty.
+var intervals = map[string]struct {
+	step     time.Duration
+	span     time.Duration
+	maxRange time.Duration
+}{
+	"5m": {5 * time.Minute, 24 * time.Hour, 24 * time.Hour},
+	"1h": {time.Hour, 7 * 24 * time.Hour, 90 * 24 * time.Hour},
+	"1d": {24 * time.Hour, 90 * 24 * time.Hour, 365 * 24 * time.Hour},
+}
+
+type Candle struct {
+	Time  time.Time `json:"time"` // start of the interval
+	Open  float64   `json:"open"`
+	High  float64   `json:"high"`
+	Low   float64   `json:"low"`
+	Close float64   `json:"close"`
+}
+
+// historyRange is a history request with from and to aligned to interval
+// boundaries, so requests made within the same interval share a cache entry.
+type historyRange struct {
+	Interval string
+	Step     time.Duration
+	From     time.Time
+	To       time.Time
+}
+
+func (hr historyRange) key() string {
+	return fmt.Sprintf("%s:%d:%d", hr.Interval, hr.From.Unix(), hr.To.Unix())
+}
+
+// defaultHistoryRange is the range of a request without parameters, the one
+// the collector keeps warm.
+func defaultHistoryRange(now time.Time) historyRange {
+	hr, _ := parseHistoryRange(url.Values{}, now)
+	return hr
+}
+
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, ErrInvalidTime
+	}
+	return t.UTC(), nil
+}
+
+// parseHistoryRange reads interval, from and to. To defaults to now and is
+// capped by it, from defaults to the interval's default span before to.
+func parseHistoryRange(query url.Values, now time.Time) (historyRange, error) {
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = defaultInterval
+	}
+	spec, ok := intervals[interval]
+	if !ok {
+		return historyRange{}, ErrInvalidInterval
+	}
+
+	to := now.UTC()
+	if value := query.Get("to"); value != "" {
+		t, err := parseTime(value)
+		if err != nil {
+			return historyRange{}, err
+		}
+		if t.Before(to) {
+			to = t
+		}
+	}
+
+	hr := historyRange{
+		Interval: interval,
+		Step:     spec.step,
+		To:       to.Truncate(spec.step),
+	}
+	if hr.To.Before(to) {
+		hr.To = hr.To.Add(spec.step)
+	}
+
+	// the default span is counted from the aligned to, so it does not grow
+	// past a day and make the provider switch to a coarser granularity
+	from := hr.To.Add(-spec.span)
+	if value := query.Get("from"); value != "" {
+		t, err := parseTime(value)
+		if err != nil {
+			return historyRange{}, err
+		}
+		from = t
+	}
+
+	if !from.Before(to) || to.Sub(from) > spec.maxRange {
+		return historyRange{}, ErrInvalidRange
+	}
+	// aligning may stretch the range by up to two steps, which must not push
+	// it over the limit either
+	hr.From = from.Truncate(spec.step)
+	if earliest := hr.To.Add(-spec.maxRange); hr.From.Before(earliest) {
+		hr.From = earliest
+	}
+	return hr, nil
+}
+
+// parsePage reads page (1-based) and limit, the number of candles per page.
+func parsePage(query url.Values) (int, int, error) {
+	page, limit := 1, defaultPageSize
+	if value := query.Get("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, ErrInvalidPage
+		}
+		page = n
+	}
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageSize {
+			return 0, 0, ErrInvalidPageSize
+		}
+		limit = n
+	}
+	return page, limit, nil
+}
+
+// buildCandles groups the points within hr into candles. Intervals without
+// points are left out rather than filled in.
+func buildCandles(points []domain.PricePoint, hr historyRange) []Candle {
+	points = slices.Clone(points)
+	slices.SortStableFunc(points, func(a, b domain.PricePoint) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	candles := make([]Candle, 0)
+	for _, point := range points {
+		if point.Timestamp.Before(hr.From) || !point.Timestamp.Before(hr.To) {
+			continue
+		}
+		start := point.Timestamp.Truncate(hr.Step)
+		last := len(candles) - 1
+		if last < 0 || !candles[last].Time.Equal(start) {
+			candles = append(candles, Candle{
+				Time:  start,
+				Open:  point.Price,
+				High:  point.Price,
+				Low:   point.Price,
+				Close: point.Price,
+			})
+			continue
+		}
+		candle := &candles[last]
+		candle.High = max(candle.High, point.Price)
+		candle.Low = min(candle.Low, point.Price)
+		candle.Close = point.Price
+	}
+	return candles
+}
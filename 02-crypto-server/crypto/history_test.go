@@ -0,0 +1,169 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"cryptoserver/provider"
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseHistoryRange(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 3, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	rfc3339 := func(t time.Time) string { return url.QueryEscape(t.Format(time.RFC3339)) }
+
+	tests := []struct {
+		name     string
+		query    string
+		wantFrom time.Time
+		wantTo   time.Time
+		wantErr  error
+	}{
+		{
+			name:     "defaults to a day of 5m candles",
+			query:    "",
+			wantFrom: time.Date(2026, 1, 9, 12, 5, 0, 0, time.UTC),
+			wantTo:   time.Date(2026, 1, 10, 12, 5, 0, 0, time.UTC),
+		},
+		{
+			name:     "1h defaults to a week",
+			query:    "interval=1h",
+			wantFrom: time.Date(2026, 1, 3, 13, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2026, 1, 10, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "from and to are aligned",
+			query:    "interval=1h&from=" + rfc3339(now.Add(-48*time.Hour)) + "&to=" + rfc3339(now.Add(-time.Hour)),
+			wantFrom: time.Date(2026, 1, 8, 12, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "unix seconds",
+			query:    "interval=1d&from=1767225600", // 2026-01-01
+			wantFrom: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2026, 1, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "to is capped at now",
+			query:    "interval=1h&to=" + rfc3339(now.Add(day)),
+			wantFrom: time.Date(2026, 1, 3, 13, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2026, 1, 10, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "aligning keeps 5m within a day",
+			query:    "from=" + rfc3339(now.Add(-day)),
+			wantFrom: time.Date(2026, 1, 9, 12, 5, 0, 0, time.UTC),
+			wantTo:   time.Date(2026, 1, 10, 12, 5, 0, 0, time.UTC),
+		},
+		{name: "unknown interval", query: "interval=1w", wantErr: ErrInvalidInterval},
+		{name: "bad time", query: "from=yesterday", wantErr: ErrInvalidTime},
+		{name: "from after to", query: "from=" + rfc3339(now.Add(time.Hour)), wantErr: ErrInvalidRange},
+		{name: "5m over a day", query: "from=" + rfc3339(now.Add(-2*day)), wantErr: ErrInvalidRange},
+		{name: "1h over 90 days", query: "interval=1h&from=" + rfc3339(now.Add(-91*day)), wantErr: ErrInvalidRange},
+		{name: "1d over 365 days", query: "interval=1d&from=" + rfc3339(now.Add(-366*day)), wantErr: ErrInvalidRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hr, err := parseHistoryRange(query, now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !hr.From.Equal(tt.wantFrom) || !hr.To.Equal(tt.wantTo) {
+				t.Errorf("range = %v - %v, want %v - %v", hr.From, hr.To, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query     string
+		wantPage  int
+		wantLimit int
+		wantErr   error
+	}{
+		{"", 1, defaultPageSize, nil},
+		{"page=3&limit=10", 3, 10, nil},
+		{"page=0", 0, 0, ErrInvalidPage},
+		{"page=x", 0, 0, ErrInvalidPage},
+		{"limit=0", 0, 0, ErrInvalidPageSize},
+		{"limit=1001", 0, 0, ErrInvalidPageSize},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		page, limit, err := parsePage(query)
+		if !errors.Is(err, tt.wantErr) || page != tt.wantPage || limit != tt.wantLimit {
+			t.Errorf("parsePage(%q) = %d, %d, %v, want %d, %d, %v",
+				tt.query, page, limit, err, tt.wantPage, tt.wantLimit, tt.wantErr)
+		}
+	}
+}
+
+func TestBuildCandles(t *testing.T) {
+	start := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	fake := provider.NewFake()
+	fake.Add(domain.Coin{
+		ID:           "bitcoin",
+		Symbol:       "btc",
+		Name:         "Bitcoin",
+		CurrentPrice: 100,
+		Prices:       map[string]float64{"usd": 100, "eur": 50},
+	}, []domain.PricePoint{
+		{Price: 12, Timestamp: at(3)}, // out of order on purpose
+		{Price: 10, Timestamp: at(0)},
+		{Price: 14, Timestamp: at(1)},
+		{Price: 8, Timestamp: at(2)},
+		{Price: 20, Timestamp: at(12)}, // 5-10 has no points
+		{Price: 30, Timestamp: at(-1)}, // before from
+		{Price: 40, Timestamp: at(15)}, // at to, excluded
+	})
+
+	hr := historyRange{Interval: "5m", Step: 5 * time.Minute, From: start, To: at(15)}
+	tests := []struct {
+		currency string
+		want     []Candle
+	}{
+		{"usd", []Candle{
+			{Time: at(0), Open: 10, High: 14, Low: 8, Close: 12},
+			{Time: at(10), Open: 20, High: 20, Low: 20, Close: 20},
+		}},
+		{"eur", []Candle{
+			{Time: at(0), Open: 5, High: 7, Low: 4, Close: 6},
+			{Time: at(10), Open: 10, High: 10, Low: 10, Close: 10},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			points, err := fake.GetHistory(context.Background(), "bitcoin", tt.currency, at(-5), at(20))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buildCandles(points, hr); !slices.Equal(got, tt.want) {
+				t.Errorf("candles = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := fake.GetHistory(context.Background(), "bitcoin", "rub", at(-5), at(20)); !errors.Is(err, domain.ErrNoPrice) {
+		t.Errorf("history in an unpriced currency: err = %v, want %v", err, domain.ErrNoPrice)
+	}
+}
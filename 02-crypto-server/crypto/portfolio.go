@@ -0,0 +1,404 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"cryptoserver/errorfmt"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+// quantityEpsilon absorbs float rounding when a sell closes a position.
+const quantityEpsilon = 1e-9
+
+var (
+	ErrInvalidSide          = apperror.New(apperror.Validation, "invalid_side", "Side must be buy or sell.")
+	ErrInvalidQuantity      = apperror.New(apperror.Validation, "invalid_quantity", "Quantity must be positive.")
+	ErrInvalidPrice         = apperror.New(apperror.Validation, "invalid_price", "Price must not be negative.")
+	ErrInvalidTimestamp     = apperror.New(apperror.Validation, "invalid_timestamp", "Timestamp must not be in the future.")
+	ErrInsufficientHoldings = apperror.New(apperror.Conflict, "insufficient_holdings", "Sells would exceed the quantity held at the time.")
+	ErrTransactionNotFound  = apperror.New(apperror.NotFound, "transaction_not_found", "Transaction doesn't exist.")
+)
+
+type TransactionRequest struct {
+	Symbol    string    `json:"symbol"`
+	Side      string    `json:"side"`
+	Quantity  float64   `json:"quantity"`
+	Price     float64   `json:"price"`
+	Currency  string    `json:"currency,omitempty"` // the caller's currency if empty
+	Timestamp time.Time `json:"timestamp"`          // now if empty
+}
+
+type TransactionResponse struct {
+	ID        string    `json:"id"`
+	Symbol    string    `json:"symbol"`
+	Side      string    `json:"side"`
+	Quantity  float64   `json:"quantity"`
+	Price     float64   `json:"price"`
+	Currency  string    `json:"currency"`
+	Timestamp time.Time `json:"timestamp"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type HoldingResponse struct {
+	Symbol        string  `json:"symbol"`
+	Quantity      float64 `json:"quantity"`
+	AverageCost   float64 `json:"average_cost"`
+	CostBasis     float64 `json:"cost_basis"`
+	CurrentPrice  float64 `json:"current_price"`
+	Value         float64 `json:"value"`
+	RealizedPnL   float64 `json:"realized_pnl"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+	Allocation    float64 `json:"allocation_percent"`
+}
+
+type PortfolioResponse struct {
+	Currency      string            `json:"currency"`
+	Value         float64           `json:"value"`
+	CostBasis     float64           `json:"cost_basis"`
+	RealizedPnL   float64           `json:"realized_pnl"`
+	UnrealizedPnL float64           `json:"unrealized_pnl"`
+	Holdings      []HoldingResponse `json:"holdings"`
+}
+
+func newTransactionResponse(tx *domain.Transaction) TransactionResponse {
+	return TransactionResponse{
+		ID:        tx.ID,
+		Symbol:    tx.Symbol,
+		Side:      string(tx.Side),
+		Quantity:  tx.Quantity,
+		Price:     tx.Price,
+		Currency:  tx.Currency,
+		Timestamp: tx.Timestamp,
+		CreatedAt: tx.CreatedAt,
+	}
+}
+
+func (request *TransactionRequest) transaction(now time.Time) (*domain.Transaction, error) {
+	tx := &domain.Transaction{
+		Symbol:    request.Symbol,
+		Side:      domain.TradeSide(request.Side),
+		Quantity:  request.Quantity,
+		Price:     request.Price,
+		Currency:  strings.ToLower(request.Currency),
+		Timestamp: request.Timestamp.UTC(),
+	}
+
+	if tx.Side != domain.TradeBuy && tx.Side != domain.TradeSell {
+		return nil, ErrInvalidSide
+	}
+	if tx.Quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+	if tx.Price < 0 {
+		return nil, ErrInvalidPrice
+	}
+	if tx.Currency != "" && !domain.Currencies[tx.Currency] {
+		return nil, domain.ErrInvalidCurrency
+	}
+	if tx.Timestamp.IsZero() {
+		tx.Timestamp = now
+	} else if tx.Timestamp.After(now) {
+		return nil, ErrInvalidTimestamp
+	}
+	return tx, nil
+}
+
+// position is the running state of one coin while replaying transactions.
+type position struct {
+	coinID   string
+	symbol   string
+	quantity float64
+	cost     float64 // cost basis of the quantity held
+	realized float64
+}
+
+// replay applies txs, ordered oldest first, with average cost accounting: a
+// sell realizes its price minus the average cost of the coins held. price
+// gives a transaction's price in the reporting currency. Positions are
+// returned in the order the coins were first traded.
+func replay(txs []domain.Transaction, price func(tx *domain.Transaction) float64) ([]*position, error) {
+	byCoin := make(map[string]*position)
+	positions := make([]*position, 0)
+	for i := range txs {
+		tx := &txs[i]
+		pos, ok := byCoin[tx.CoinID]
+		if !ok {
+			pos = &position{coinID: tx.CoinID, symbol: tx.Symbol}
+			byCoin[tx.CoinID] = pos
+			positions = append(positions, pos)
+		}
+
+		switch tx.Side {
+		case domain.TradeBuy:
+			pos.quantity += tx.Quantity
+			pos.cost += tx.Quantity * price(tx)
+		case domain.TradeSell:
+			if pos.quantity == 0 || tx.Quantity-pos.quantity > quantityEpsilon {
+				return nil, ErrInsufficientHoldings
+			}
+			sold := min(tx.Quantity, pos.quantity)
+			averageCost := pos.cost / pos.quantity
+			pos.realized += sold * (price(tx) - averageCost)
+			pos.cost -= sold * averageCost
+			pos.quantity -= sold
+			if pos.quantity < quantityEpsilon {
+				pos.quantity, pos.cost = 0, 0
+			}
+		}
+	}
+	return positions, nil
+}
+
+func tradePrice(tx *domain.Transaction) float64 {
+	return tx.Price
+}
+
+// replayable rejects transactions where a sell exceeds the coins held.
+func replayable(txs []domain.Transaction) error {
+	_, err := replay(txs, tradePrice)
+	return err
+}
+
+// currentPrice is the price GetCrypto serves for id in currency.
+func (api *API) currentPrice(ctx context.Context, id, currency string) (float64, error) {
+	cached, err := api.coinJSON(ctx, id, currency)
+	if err != nil {
+		return 0, err
+	}
+	coin := CoinResponse{}
+	if err := json.Unmarshal(cached.Value, &coin); err != nil {
+		return 0, err
+	}
+	return coin.CurrentPrice, nil
+}
+
+// currentPrices looks up the current price of every coin in txs in currency
+// and in every other currency the transactions were entered in, keyed by
+// coinKey.
+func (api *API) currentPrices(ctx context.Context, txs []domain.Transaction, currency string) (map[string]float64, error) {
+	type quote struct{ id, currency string }
+	quotes := make(map[quote]bool)
+	for _, tx := range txs {
+		quotes[quote{tx.CoinID, currency}] = true
+		quotes[quote{tx.CoinID, tx.Currency}] = true
+	}
+
+	var mu sync.Mutex
+	prices := make(map[string]float64, len(quotes))
+	g, ctx := errgroup.WithContext(ctx)
+	for q := range quotes {
+		g.Go(func() error {
+			price, err := api.currentPrice(ctx, q.id, q.currency)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			prices[coinKey(q.id, q.currency)] = price
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	return prices, g.Wait()
+}
+
+// GetPortfolio values the caller's holdings at current prices, in ?vs= or the
+// caller's currency. Trades entered in another currency are converted at the
+// coin's current exchange rate between the two.
+func (api *API) GetPortfolio(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	currency, err := quoteCurrency(r)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	txs, err := api.portfolio.Transactions(userID)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	prices, err := api.currentPrices(r.Context(), txs, currency)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	positions, err := replay(txs, func(tx *domain.Transaction) float64 {
+		if tx.Currency == currency {
+			return tx.Price
+		}
+		rate := prices[coinKey(tx.CoinID, tx.Currency)]
+		if rate == 0 {
+			return 0
+		}
+		return tx.Price * prices[coinKey(tx.CoinID, currency)] / rate
+	})
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	portfolio := PortfolioResponse{
+		Currency: currency,
+		Holdings: make([]HoldingResponse, len(positions)),
+	}
+	for i, pos := range positions {
+		holding := HoldingResponse{
+			Symbol:       pos.symbol,
+			Quantity:     pos.quantity,
+			CostBasis:    pos.cost,
+			CurrentPrice: prices[coinKey(pos.coinID, currency)],
+			RealizedPnL:  pos.realized,
+		}
+		if pos.quantity > 0 {
+			holding.AverageCost = pos.cost / pos.quantity
+		}
+		holding.Value = holding.Quantity * holding.CurrentPrice
+		holding.UnrealizedPnL = holding.Value - holding.CostBasis
+		portfolio.Holdings[i] = holding
+
+		portfolio.Value += holding.Value
+		portfolio.CostBasis += holding.CostBasis
+		portfolio.RealizedPnL += holding.RealizedPnL
+		portfolio.UnrealizedPnL += holding.UnrealizedPnL
+	}
+	if portfolio.Value > 0 {
+		for i := range portfolio.Holdings {
+			portfolio.Holdings[i].Allocation = portfolio.Holdings[i].Value / portfolio.Value * 100
+		}
+	}
+
+	clientJSON, err := json.Marshal(portfolio)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(clientJSON)
+}
+
+func (api *API) ListTransactions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	txs, err := api.portfolio.Transactions(userID)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	transactions := make([]TransactionResponse, len(txs))
+	for i := range txs {
+		transactions[i] = newTransactionResponse(&txs[i])
+	}
+
+	clientJSON, err := json.Marshal(map[string][]TransactionResponse{"transactions": transactions})
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(clientJSON)
+}
+
+// AddTransaction records a trade. A sell, or a buy backdated before earlier
+// sells, is rejected if some sell would then exceed the coins held.
+func (api *API) AddTransaction(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	request := TransactionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		errorfmt.Write(w, r, ErrInvalidJSON)
+		return
+	}
+
+	now := time.Now().UTC()
+	tx, err := request.transaction(now)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+	if tx.Currency == "" {
+		if tx.Currency, err = quoteCurrency(r); err != nil {
+			errorfmt.Write(w, r, err)
+			return
+		}
+	}
+
+	id, err := api.getID(r.Context(), request.Symbol)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	tx.ID = uuid.NewString()
+	tx.UserID = userID
+	tx.CoinID = id
+	tx.CreatedAt = now
+
+	if err := api.portfolio.AddTransaction(tx, replayable); err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	clientJSON, err := json.Marshal(newTransactionResponse(tx))
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(clientJSON)
+}
+
+// DeleteTransaction removes a trade, unless that leaves a later sell without
+// the coins it sold.
+func (api *API) DeleteTransaction(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	txID := chi.URLParam(r, "id")
+	deleted, err := api.portfolio.DeleteTransaction(userID, txID, replayable)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	} else if !deleted {
+		errorfmt.Write(w, r, ErrTransactionNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
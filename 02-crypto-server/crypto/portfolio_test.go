@@ -0,0 +1,157 @@
+package crypto
+
+import (
+	"cryptoserver/clean/domain"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	buy := func(coin string, quantity, price float64) domain.Transaction {
+		return domain.Transaction{CoinID: coin, Symbol: coin, Side: domain.TradeBuy, Quantity: quantity, Price: price}
+	}
+	sell := func(coin string, quantity, price float64) domain.Transaction {
+		return domain.Transaction{CoinID: coin, Symbol: coin, Side: domain.TradeSell, Quantity: quantity, Price: price}
+	}
+
+	tests := []struct {
+		name    string
+		txs     []domain.Transaction
+		want    []position
+		wantErr error
+	}{
+		{
+			name: "buys average their cost",
+			txs:  []domain.Transaction{buy("btc", 1, 100), buy("btc", 1, 200)},
+			want: []position{{coinID: "btc", symbol: "btc", quantity: 2, cost: 300}},
+		},
+		{
+			name: "sell realizes against the average cost",
+			txs:  []domain.Transaction{buy("btc", 1, 100), buy("btc", 1, 200), sell("btc", 1, 250)},
+			want: []position{{coinID: "btc", symbol: "btc", quantity: 1, cost: 150, realized: 100}},
+		},
+		{
+			name: "selling at a loss",
+			txs:  []domain.Transaction{buy("btc", 2, 100), sell("btc", 2, 60)},
+			want: []position{{coinID: "btc", symbol: "btc", realized: -80}},
+		},
+		{
+			name: "closing absorbs rounding",
+			txs:  []domain.Transaction{buy("btc", 0.1, 10), buy("btc", 0.2, 10), sell("btc", 0.3, 10)},
+			want: []position{{coinID: "btc", symbol: "btc"}},
+		},
+		{
+			name: "positions keep the order coins were first traded",
+			txs:  []domain.Transaction{buy("eth", 1, 10), buy("btc", 1, 100), buy("eth", 1, 20)},
+			want: []position{
+				{coinID: "eth", symbol: "eth", quantity: 2, cost: 30},
+				{coinID: "btc", symbol: "btc", quantity: 1, cost: 100},
+			},
+		},
+		{
+			name:    "sell without holdings",
+			txs:     []domain.Transaction{sell("btc", 1, 100)},
+			wantErr: ErrInsufficientHoldings,
+		},
+		{
+			name:    "sell more than held",
+			txs:     []domain.Transaction{buy("btc", 1, 100), sell("btc", 1.5, 100)},
+			wantErr: ErrInsufficientHoldings,
+		},
+		{
+			name:    "sell after the position was closed",
+			txs:     []domain.Transaction{buy("btc", 1, 100), sell("btc", 1, 100), sell("btc", 1, 100)},
+			wantErr: ErrInsufficientHoldings,
+		},
+	}
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			positions, err := replay(tt.txs, tradePrice)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(positions) != len(tt.want) {
+				t.Fatalf("got %d positions, want %d", len(positions), len(tt.want))
+			}
+			for i, got := range positions {
+				want := tt.want[i]
+				if got.coinID != want.coinID || got.symbol != want.symbol ||
+					!near(got.quantity, want.quantity) || !near(got.cost, want.cost) || !near(got.realized, want.realized) {
+					t.Errorf("position %d = %+v, want %+v", i, *got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrentSells(t *testing.T) {
+	ta := newTestAPI(t)
+	trade := func(side string) map[string]any {
+		return map[string]any{"symbol": "btc", "side": side, "quantity": 1, "price": 100}
+	}
+	if rec := ta.do(t, "alice", http.MethodPost, "/portfolio/transactions", trade("buy"), nil); rec.Code != http.StatusCreated {
+		t.Fatalf("buy: %d %s", rec.Code, rec.Body)
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Go(func() {
+			codes[i] = ta.do(t, "alice", http.MethodPost, "/portfolio/transactions", trade("sell"), nil).Code
+		})
+	}
+	wg.Wait()
+
+	sold := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			sold++
+		case http.StatusConflict:
+		default:
+			t.Errorf("sell: %d, want 201 or 409", code)
+		}
+	}
+	if sold != 1 {
+		t.Errorf("%d concurrent sells of the only coin succeeded, want 1", sold)
+	}
+
+	var portfolio PortfolioResponse
+	ta.do(t, "alice", http.MethodGet, "/portfolio", nil, &portfolio)
+	if len(portfolio.Holdings) != 1 || portfolio.Holdings[0].Quantity != 0 {
+		t.Errorf("holdings = %+v, want a closed btc position", portfolio.Holdings)
+	}
+}
+
+func TestDeleteTransaction(t *testing.T) {
+	ta := newTestAPI(t)
+	var buy, sell TransactionResponse
+	ta.do(t, "alice", http.MethodPost, "/portfolio/transactions", map[string]any{"symbol": "btc", "side": "buy", "quantity": 2, "price": 50}, &buy)
+	ta.do(t, "alice", http.MethodPost, "/portfolio/transactions", map[string]any{"symbol": "btc", "side": "sell", "quantity": 1, "price": 80}, &sell)
+
+	tests := []struct {
+		name       string
+		user       string
+		id         string
+		wantStatus int
+		wantCode   string
+	}{
+		{"buy a sell depends on", "alice", buy.ID, http.StatusConflict, "insufficient_holdings"},
+		{"another user's trade", "bob", sell.ID, http.StatusNotFound, "transaction_not_found"},
+		{"sell", "alice", sell.ID, http.StatusOK, ""},
+		{"deleted sell", "alice", sell.ID, http.StatusNotFound, "transaction_not_found"},
+		{"buy without sells", "alice", buy.ID, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		rec := ta.do(t, tt.user, http.MethodDelete, "/portfolio/transactions/"+tt.id, nil, nil)
+		if rec.Code != tt.wantStatus || (tt.wantCode != "" && problemCode(rec) != tt.wantCode) {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, rec.Code, problemCode(rec), tt.wantStatus, tt.wantCode)
+		}
+	}
+}
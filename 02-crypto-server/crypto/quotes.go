@@ -0,0 +1,248 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/apperror"
+	"cryptoserver/errorfmt"
+	"cryptoserver/metrics"
+	"cryptoserver/swr"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const maxQuotes = 100
+
+var (
+	ErrNoSymbols     = apperror.New(apperror.Validation, "missing_symbols", "At least one symbol is required.")
+	ErrTooManyQuotes = apperror.New(apperror.Validation, "too_many_quotes", "At most 100 symbols per request.")
+)
+
+// QuoteResult is the quote of one requested symbol, or why there is none.
+type QuoteResult struct {
+	Symbol string        `json:"symbol"`
+	Quote  *CoinResponse `json:"quote,omitempty"`
+	Error  *QuoteError   `json:"error,omitempty"`
+}
+
+type QuoteError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type QuotesResponse struct {
+	Currency string        `json:"currency"`
+	Quotes   []QuoteResult `json:"quotes"`
+}
+
+func quoteError(err error) *QuoteError {
+	appErr := apperror.Classify(err)
+	return &QuoteError{Code: appErr.Code, Message: appErr.Message}
+}
+
+// parseSymbols trims and de-duplicates symbols, keeping their order.
+func parseSymbols(symbols []string) ([]string, error) {
+	seen := make(map[string]bool, len(symbols))
+	parsed := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		symbol = strings.TrimSpace(symbol)
+		if symbol == "" || seen[strings.ToLower(symbol)] {
+			continue
+		}
+		seen[strings.ToLower(symbol)] = true
+		parsed = append(parsed, symbol)
+	}
+
+	if len(parsed) == 0 {
+		return nil, ErrNoSymbols
+	} else if len(parsed) > maxQuotes {
+		return nil, ErrTooManyQuotes
+	}
+	return parsed, nil
+}
+
+// GetQuotes is GET /crypto/quotes?symbols=btc,eth.
+func (api *API) GetQuotes(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	api.quotes(w, r, strings.Split(r.URL.Query().Get("symbols"), ","))
+}
+
+// PostQuotes takes {"symbols":["btc","eth"]}, for lists too long for a URL.
+func (api *API) PostQuotes(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var body struct {
+		Symbols []string `json:"symbols"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		errorfmt.Write(w, r, ErrInvalidJSON)
+		return
+	}
+	api.quotes(w, r, body.Symbols)
+}
+
+// quotes answers every symbol it can: cached coins are served as is, stale
+// ones are refreshed in the background and the misses are priced with a
+// single upstream call, after at most one more to load the symbol index. A
+// symbol that fails gets an inline error instead of failing the batch.
+func (api *API) quotes(w http.ResponseWriter, r *http.Request, requested []string) {
+	symbols, err := parseSymbols(requested)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	currency, err := quoteCurrency(r)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	// one coin list call instead of a search per symbol while the index is cold
+	if err := api.loadSymbols(r.Context()); err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	results := make([]QuoteResult, len(symbols))
+	ids := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		results[i].Symbol = symbol
+		id, err := api.getID(r.Context(), symbol)
+		if err != nil {
+			results[i].Error = quoteError(err)
+			continue
+		}
+		ids[i] = id
+	}
+
+	quotes, cached, err := api.getQuotes(r.Context(), ids, currency)
+	for i, id := range ids {
+		if id == "" {
+			continue
+		}
+		if quote, ok := quotes[id]; ok {
+			results[i].Quote = quote
+		} else if err != nil {
+			results[i].Error = quoteError(err)
+		} else {
+			results[i].Error = quoteError(ErrNoID)
+		}
+	}
+
+	clientJSON, err := json.Marshal(QuotesResponse{Currency: currency, Quotes: results})
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	cached.WriteHeaders(w)
+	w.WriteHeader(http.StatusOK)
+	w.Write(clientJSON)
+}
+
+// getQuotes returns the CoinResponse of every id it could price, by id. The
+// error is the upstream one, when the misses could not be fetched. Empty ids
+// are skipped. The result describes the batch as a whole: Miss if anything
+// was fetched, else Stale if anything was stale, aged by the oldest entry.
+func (api *API) getQuotes(ctx context.Context, ids []string, currency string) (map[string]*CoinResponse, swr.Result, error) {
+	quotes := make(map[string]*CoinResponse, len(ids))
+	batch := swr.Result{Status: swr.Hit}
+	seen := make(map[string]bool, len(ids))
+	keys := make([]string, 0, len(ids))
+	wanted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		keys = append(keys, coinKey(id, currency))
+		wanted = append(wanted, id)
+	}
+	if len(wanted) == 0 {
+		return quotes, batch, nil
+	}
+
+	cached, err := api.coins.LookupMany(ctx, keys...)
+	if err != nil {
+		slog.WarnContext(ctx, "cache lookup failed", "err", err)
+		cached = make([]swr.Result, len(keys)) // treat as all misses
+	}
+
+	misses := make([]string, 0)
+	stale := make(map[string]string) // key -> id
+	for i, id := range wanted {
+		quote := &CoinResponse{}
+		if cached[i].Value == nil || json.Unmarshal(cached[i].Value, quote) != nil {
+			metrics.ObserveCacheResult(coinCachePrefix, "miss")
+			misses = append(misses, id)
+			continue
+		}
+
+		quotes[id] = quote
+		batch.Age = max(batch.Age, cached[i].Age)
+		if cached[i].Status == swr.Stale {
+			metrics.ObserveCacheResult(coinCachePrefix, "stale")
+			stale[keys[i]] = id
+		} else {
+			metrics.ObserveCacheResult(coinCachePrefix, "hit")
+		}
+	}
+
+	if len(stale) > 0 {
+		batch.Status = swr.Stale
+		api.coins.RevalidateMany(ctx, slices.Collect(maps.Keys(stale)), func(ctx context.Context, keys []string) error {
+			ids := make([]string, len(keys))
+			for i, key := range keys {
+				ids[i] = stale[key]
+			}
+			_, err := api.fetchQuotes(ctx, ids, currency)
+			return err
+		})
+	}
+	if len(misses) == 0 {
+		return quotes, batch, nil
+	}
+
+	batch.Status = swr.Miss
+	fetched, err := api.fetchQuotes(ctx, misses, currency)
+	if err != nil {
+		return quotes, batch, err
+	}
+	for id, quote := range fetched {
+		quotes[id] = quote
+	}
+	return quotes, batch, nil
+}
+
+// fetchQuotes prices ids with one upstream call and caches the results.
+func (api *API) fetchQuotes(ctx context.Context, ids []string, currency string) (map[string]*CoinResponse, error) {
+	coins, err := api.provider.GetQuotes(ctx, ids, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]*CoinResponse, len(coins))
+	for i := range coins {
+		coin := &coins[i]
+		api.sample(ctx, coin)
+
+		quote, err := newCoinResponse(coin, currency)
+		if err != nil {
+			slog.WarnContext(ctx, "quote without price", "coin", coin.ID, "currency", currency)
+			continue
+		}
+		quotes[coin.ID] = &quote
+		coinJSON, err := json.Marshal(quote)
+		if err != nil {
+			return nil, err
+		}
+		if err := api.coins.Store(ctx, coinKey(coin.ID, currency), coinJSON); err != nil {
+			slog.ErrorContext(ctx, "caching quote failed", "coin", coin.ID, "err", err)
+		}
+	}
+	return quotes, nil
+}
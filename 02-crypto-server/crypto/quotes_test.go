@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestQuotes(t *testing.T) {
+	ta := newTestAPI(t)
+
+	var quotes QuotesResponse
+	rec := ta.do(t, "alice", http.MethodGet, "/crypto/quotes?symbols=btc,ETH,doge,sol,btc,,bitcoin&vs=eur", nil, &quotes)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("cold quotes: %d %s %s", rec.Code, rec.Header().Get("X-Cache"), rec.Body)
+	}
+
+	type result struct{ symbol, quote, err string }
+	want := []result{
+		{"btc", "Bitcoin 90 eur", ""},
+		{"ETH", "Ethereum 9 eur", ""},
+		{"doge", "", "symbol_not_found"},
+		{"sol", "", "ambiguous_symbol"},
+		{"bitcoin", "Bitcoin 90 eur", ""},
+	}
+	got := make([]result, len(quotes.Quotes))
+	for i, q := range quotes.Quotes {
+		got[i].symbol = q.Symbol
+		if q.Quote != nil {
+			got[i].quote = fmt.Sprintf("%s %v %s", q.Quote.Name, q.Quote.CurrentPrice, q.Quote.Currency)
+		}
+		if q.Error != nil {
+			got[i].err = q.Error.Code
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("quotes = %v, want %v", got, want)
+	}
+
+	// the cold batch loads the index once and prices every miss in one call
+	if search, list, batch := ta.provider.Calls("SearchSymbol"), ta.provider.Calls("ListCoins"), ta.provider.Calls("GetQuotes"); search != 0 || list != 1 || batch != 1 {
+		t.Errorf("upstream calls: %d searches, %d lists, %d batches, want 0, 1, 1", search, list, batch)
+	}
+
+	rec = ta.do(t, "alice", http.MethodPost, "/crypto/quotes?vs=eur", map[string][]string{"symbols": {"eth", "btc"}}, &quotes)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("warm quotes: %d %s", rec.Code, rec.Header().Get("X-Cache"))
+	}
+	if ta.provider.Calls("GetQuotes") != 1 {
+		t.Errorf("warm quotes called upstream")
+	}
+}
+
+func TestQuotesInvalid(t *testing.T) {
+	ta := newTestAPI(t)
+	tooMany := make([]string, maxQuotes+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprint("coin", i)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     any
+		wantCode string
+	}{
+		{"no symbols", http.MethodGet, "/crypto/quotes?symbols=,,", nil, "missing_symbols"},
+		{"too many symbols", http.MethodGet, "/crypto/quotes?symbols=" + strings.Join(tooMany, ","), nil, "too_many_quotes"},
+		{"unknown currency", http.MethodGet, "/crypto/quotes?symbols=btc&vs=jpy", nil, "invalid_currency"},
+		{"bad body", http.MethodPost, "/crypto/quotes", "btc", "invalid_json"},
+	}
+
+	for _, tt := range tests {
+		rec := ta.do(t, "alice", tt.method, tt.path, tt.body, nil)
+		if rec.Code != http.StatusBadRequest || problemCode(rec) != tt.wantCode {
+			t.Errorf("%s: %d %s, want 400 %s", tt.name, rec.Code, problemCode(rec), tt.wantCode)
+		}
+	}
+	if calls := ta.provider.Calls("ListCoins") + ta.provider.Calls("GetQuotes"); calls != 0 {
+		t.Errorf("rejected requests made %d upstream calls", calls)
+	}
+}
@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"time"
+)
+
+var ErrInvalidWindow = apperror.New(apperror.Validation, "invalid_window", "Window must be one of 1h, 24h, 7d.")
+
+const defaultWindow = "24h"
+
+var windows = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+func parseWindow(window string) (time.Duration, error) {
+	if window == "" {
+		window = defaultWindow
+	}
+	d, ok := windows[window]
+	if !ok {
+		return 0, ErrInvalidWindow
+	}
+	return d, nil
+}
+
+// computeRecord summarises samples ordered oldest first.
+func computeRecord(samples []domain.PricePoint) Record {
+	if len(samples) == 0 {
+		return Record{}
+	}
+
+	first, last := samples[0].Price, samples[len(samples)-1].Price
+	record := Record{
+		MinPrice:     first,
+		MaxPrice:     first,
+		PriceChange:  last - first,
+		RecordsCount: len(samples),
+	}
+
+	sum := 0.0
+	for _, sample := range samples {
+		record.MinPrice = min(record.MinPrice, sample.Price)
+		record.MaxPrice = max(record.MaxPrice, sample.Price)
+		sum += sample.Price
+	}
+	record.AvgPrice = sum / float64(len(samples))
+
+	if first != 0 {
+		record.PriceChangePercent = record.PriceChange / first * 100
+	}
+
+	return record
+}
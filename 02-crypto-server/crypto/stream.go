@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/errorfmt"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	heartbeatInterval = 15 * time.Second
+	// streamWriteTimeout replaces server.write_timeout for every write of a
+	// stream, which would otherwise cut it off after the first period.
+	streamWriteTimeout = 10 * time.Second
+	streamRetry        = 3 * time.Second
+)
+
+// watchedIDs returns the ids of the coins in the user's watchlist.
+func (api *API) watchedIDs(ctx context.Context, userID string) (map[string]bool, error) {
+	const keysPerRequest = 100
+	watched := make(map[string]bool)
+	iter := api.cache.Scan(ctx, 0, watchKey(userID, "*"), keysPerRequest).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		watched[key[strings.LastIndex(key, ":")+1:]] = true
+	}
+	return watched, iter.Err()
+}
+
+// StopStreams ends every open stream, it is registered with
+// http.Server.RegisterOnShutdown since Shutdown does not wait for them.
+func (api *API) StopStreams() {
+	api.stopStreams()
+}
+
+// StreamCryptos pushes a "price" event every time a watched coin of the
+// caller is refreshed. The watchlist is re-read on every heartbeat, so coins
+// watched after connecting show up within heartbeatInterval. Event ids are
+// tick sequence numbers: a client reconnecting with Last-Event-ID gets the
+// ticks it missed first, as long as they are still in the backlog. That
+// includes clients disconnected for falling behind.
+func (api *API) StreamCryptos(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	watched, err := api.watchedIDs(r.Context(), userID)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	since, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	disconnect := api.stream.Overflow == "disconnect"
+	sub, missed := api.ticks.subscribe(api.stream.QueueSize, disconnect, watched, since)
+	defer func() {
+		dropped := api.ticks.unsubscribe(sub)
+		slog.DebugContext(r.Context(), "stream closed", "dropped", dropped)
+	}()
+
+	rc := http.NewResponseController(w)
+	send := func(format string, args ...any) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	sendTick := func(tick Tick) bool {
+		tickJSON, err := json.Marshal(tick)
+		if err != nil {
+			return false
+		}
+		return send("id: %d\nevent: price\ndata: %s\n\n", tick.Seq, tickJSON)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if !send("retry: %d\n\n", streamRetry.Milliseconds()) {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "stream opened", "since", since, "missed", len(missed))
+
+	for _, tick := range missed {
+		if !sendTick(tick) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-api.streams.Done():
+			return
+		case <-sub.gone:
+			return
+		case <-heartbeat.C:
+			if watched, err := api.watchedIDs(r.Context(), userID); err == nil {
+				api.ticks.follow(sub, watched)
+			}
+			if !send(": heartbeat\n\n") {
+				return
+			}
+		case tick := <-sub.ticks:
+			if !sendTick(tick) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"cryptoserver/errorfmt"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// symbolsRetry is how soon a failed coin list load is retried.
+	symbolsRetry = time.Minute
+)
+
+var (
+	ErrAmbiguousSymbol = apperror.New(apperror.Conflict, "ambiguous_symbol", "Symbol is shared by several coins, address one by id.")
+	ErrEmptyQuery      = apperror.New(apperror.Validation, "empty_query", "Query must not be empty.")
+)
+
+type CoinCandidate struct {
+	ID     string `json:"id"`
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+}
+
+func newCoinCandidate(coin domain.CoinListing) CoinCandidate {
+	return CoinCandidate{ID: coin.ID, Symbol: coin.Symbol, Name: coin.Name}
+}
+
+func ambiguous(candidates []domain.CoinListing) error {
+	formated := make([]CoinCandidate, len(candidates))
+	for i, coin := range candidates {
+		formated[i] = newCoinCandidate(coin)
+	}
+	return ErrAmbiguousSymbol.WithExtension("candidates", formated)
+}
+
+// symbolIndex maps lower-case symbols to every coin using them, built from
+// the provider's coin list. Until the first load succeeds it is empty and
+// lookups fall back to the provider search.
+type symbolIndex struct {
+	mu       sync.RWMutex
+	pinned   map[string]string // symbol -> id, see config.Symbols
+	coins    []domain.CoinListing
+	byID     map[string]domain.CoinListing
+	bySymbol map[string][]domain.CoinListing
+}
+
+func newSymbolIndex(pinned map[string]string) *symbolIndex {
+	lower := make(map[string]string, len(pinned))
+	for symbol, id := range pinned {
+		lower[strings.ToLower(symbol)] = id
+	}
+	return &symbolIndex{pinned: lower}
+}
+
+func (idx *symbolIndex) load(coins []domain.CoinListing) {
+	byID := make(map[string]domain.CoinListing, len(coins))
+	bySymbol := make(map[string][]domain.CoinListing, len(coins))
+	for _, coin := range coins {
+		byID[coin.ID] = coin
+		symbol := strings.ToLower(coin.Symbol)
+		bySymbol[symbol] = append(bySymbol[symbol], coin)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.coins, idx.byID, idx.bySymbol = coins, byID, bySymbol
+}
+
+func (idx *symbolIndex) loaded() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.byID != nil
+}
+
+// resolve turns a canonical id or a symbol into an id. A symbol shared by
+// several coins resolves only if it is pinned. ok is false while the index
+// is not loaded.
+func (idx *symbolIndex) resolve(symbol string) (id string, ok bool, err error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.byID == nil {
+		return "", false, nil
+	}
+
+	key := strings.ToLower(symbol)
+	if _, exists := idx.byID[key]; exists {
+		return key, true, nil
+	}
+	if id, pinned := idx.pinned[key]; pinned {
+		if _, exists := idx.byID[id]; exists {
+			return id, true, nil
+		}
+	}
+
+	candidates := idx.bySymbol[key]
+	switch len(candidates) {
+	case 0:
+		return "", true, domain.ErrNoID
+	case 1:
+		return candidates[0].ID, true, nil
+	default:
+		return "", true, ambiguous(candidates)
+	}
+}
+
+// search ranks coins matching q: exact id, then exact symbol with the pinned
+// coin first, then symbol prefix, then id or name containing q.
+func (idx *symbolIndex) search(q string, limit int) []domain.CoinListing {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	q = strings.ToLower(q)
+	rank := func(coin domain.CoinListing) int {
+		symbol := strings.ToLower(coin.Symbol)
+		switch {
+		case coin.ID == q:
+			return 0
+		case symbol == q && idx.pinned[q] == coin.ID:
+			return 1
+		case symbol == q:
+			return 2
+		case strings.HasPrefix(symbol, q):
+			return 3
+		case strings.Contains(coin.ID, q) || strings.Contains(strings.ToLower(coin.Name), q):
+			return 4
+		default:
+			return -1
+		}
+	}
+
+	type match struct {
+		coin domain.CoinListing
+		rank int
+	}
+	matches := make([]match, 0)
+	for _, coin := range idx.coins {
+		if r := rank(coin); r >= 0 {
+			matches = append(matches, match{coin, r})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int {
+		return a.rank - b.rank
+	})
+
+	coins := make([]domain.CoinListing, 0, min(limit, len(matches)))
+	for _, m := range matches[:min(limit, len(matches))] {
+		coins = append(coins, m.coin)
+	}
+	return coins
+}
+
+func (api *API) refreshSymbols(ctx context.Context) error {
+	coins, err := api.provider.ListCoins(ctx)
+	if err != nil {
+		return err
+	}
+	api.symbols.load(coins)
+	slog.InfoContext(ctx, "symbol index loaded", "coins", len(coins))
+	return nil
+}
+
+// loadSymbols loads the symbol index unless it already is, for requests
+// that need it before RefreshSymbols got to it.
+func (api *API) loadSymbols(ctx context.Context) error {
+	if api.symbols.loaded() {
+		return nil
+	}
+	return api.refreshSymbols(ctx)
+}
+
+// RefreshSymbols reloads the symbol index every symbols.refresh_interval
+// until ctx is cancelled. A failed load is retried after symbolsRetry.
+func (api *API) RefreshSymbols(ctx context.Context) {
+	for {
+		next := api.symbolsCfg.RefreshInterval
+		if err := api.refreshSymbols(ctx); err != nil {
+			slog.WarnContext(ctx, "loading symbol index failed", "err", err)
+			next = min(next, symbolsRetry)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next):
+		}
+	}
+}
+
+func (api *API) SearchCryptos(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		errorfmt.Write(w, r, ErrEmptyQuery)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			errorfmt.Write(w, r, ErrInvalidLimit)
+			return
+		}
+	}
+
+	if err := api.loadSymbols(r.Context()); err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	matches := api.symbols.search(q, limit)
+	coins := make([]CoinCandidate, len(matches))
+	for i, coin := range matches {
+		coins[i] = newCoinCandidate(coin)
+	}
+
+	clientJSON, err := json.Marshal(map[string][]CoinCandidate{"coins": coins})
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(clientJSON)
+}
@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestGetID(t *testing.T) {
+	tests := []struct {
+		symbol  string
+		want    string
+		wantErr error
+	}{
+		{symbol: "bitcoin", want: "bitcoin"},
+		{symbol: "BTC", want: "bitcoin"},
+		{symbol: "eth", want: "ethereum"},
+		{symbol: "solana-wormhole", want: "solana-wormhole"},
+		{symbol: "doge", wantErr: domain.ErrNoID},
+	}
+
+	for _, loaded := range []bool{false, true} {
+		ta := newTestAPI(t)
+		if loaded {
+			ta.loadSymbols(t)
+		}
+		for _, tt := range tests {
+			id, err := ta.getID(context.Background(), tt.symbol)
+			if id != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("index loaded %v: getID(%q) = %q, %v, want %q, %v", loaded, tt.symbol, id, err, tt.want, tt.wantErr)
+			}
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	listings := make([]domain.CoinListing, len(testCoins))
+	for i, coin := range testCoins {
+		listings[i] = domain.CoinListing{ID: coin.ID, Symbol: coin.Symbol, Name: coin.Name}
+	}
+
+	tests := []struct {
+		name    string
+		pinned  map[string]string
+		symbol  string
+		want    string
+		wantErr error
+	}{
+		{name: "shared symbol", symbol: "sol", wantErr: ErrAmbiguousSymbol},
+		{name: "pinned shared symbol", pinned: map[string]string{"SOL": "solana"}, symbol: "Sol", want: "solana"},
+		{name: "id beats a pin", pinned: map[string]string{"bitcoin": "ethereum"}, symbol: "bitcoin", want: "bitcoin"},
+		{name: "pin to an unlisted coin", pinned: map[string]string{"sol": "gone"}, symbol: "sol", wantErr: ErrAmbiguousSymbol},
+		{name: "unknown", symbol: "doge", wantErr: domain.ErrNoID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := newSymbolIndex(tt.pinned)
+			if _, ok, _ := idx.resolve(tt.symbol); ok {
+				t.Fatal("an empty index resolved a symbol")
+			}
+			idx.load(listings)
+
+			id, ok, err := idx.resolve(tt.symbol)
+			if !ok || id != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("resolve(%q) = %q, %v, %v, want %q, %v", tt.symbol, id, ok, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAmbiguousSymbol(t *testing.T) {
+	ta := newTestAPI(t)
+	ta.loadSymbols(t)
+
+	rec := ta.do(t, "alice", http.MethodGet, "/crypto/sol", nil, nil)
+	var problem struct {
+		Code       string          `json:"code"`
+		Candidates []CoinCandidate `json:"candidates"`
+	}
+	decodeJSON(t, rec, &problem)
+	if rec.Code != http.StatusConflict || problem.Code != "ambiguous_symbol" {
+		t.Fatalf("GET /crypto/sol: %d %s, want 409 ambiguous_symbol", rec.Code, problem.Code)
+	}
+	ids := make([]string, len(problem.Candidates))
+	for i, candidate := range problem.Candidates {
+		ids[i] = candidate.ID
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{"solana", "solana-wormhole"}) {
+		t.Errorf("candidates = %v, want both solanas", ids)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ta := newTestAPI(t)
+
+	tests := []struct {
+		query      string
+		want       []string
+		wantStatus int
+	}{
+		{query: "q=sol", want: []string{"solana", "solana-wormhole"}, wantStatus: http.StatusOK},
+		{query: "q=solana-wormhole", want: []string{"solana-wormhole"}, wantStatus: http.StatusOK},
+		{query: "q=coin", want: []string{"bitcoin"}, wantStatus: http.StatusOK},
+		{query: "q=sol&limit=1", want: []string{"solana"}, wantStatus: http.StatusOK},
+		{query: "q=", wantStatus: http.StatusBadRequest},
+		{query: "q=sol&limit=0", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		var found struct {
+			Coins []CoinCandidate `json:"coins"`
+		}
+		rec := ta.do(t, "alice", http.MethodGet, "/crypto/search?"+tt.query, nil, &found)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("search %s: %d, want %d", tt.query, rec.Code, tt.wantStatus)
+			continue
+		}
+		ids := make([]string, len(found.Coins))
+		for i, coin := range found.Coins {
+			ids[i] = coin.ID
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("search %s = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package crypto
+
+import (
+	"context"
+	"cryptoserver/apperror"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsPingInterval   = 30 * time.Second
+	wsPongTimeout    = 2 * wsPingInterval
+	wsWriteTimeout   = 10 * time.Second
+	wsMaxMessage     = 4 << 10
+	maxSubscriptions = 50
+)
+
+var (
+	ErrInvalidOp         = apperror.New(apperror.Validation, "invalid_op", "Op must be subscribe or unsubscribe.")
+	ErrTooManySymbols    = apperror.New(apperror.Validation, "too_many_symbols", "At most 50 symbols per connection.")
+	errSlowConsumer      = errors.New("tick queue overflow")
+	errConnectionExpired = errors.New("access token expired")
+)
+
+var upgrader = websocket.Upgrader{
+	// the handshake already carries the access token, so there is no
+	// ambient credential a foreign origin could ride on
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// WSRequest is a client message: {"op":"subscribe","symbols":["btc"]}.
+type WSRequest struct {
+	Op      string   `json:"op"`
+	Symbols []string `json:"symbols"`
+}
+
+// WSMessage is a server message. Type is "tick", "subscribed" (the full
+// subscription list after every op, omitted when empty) or "error".
+type WSMessage struct {
+	Type    string   `json:"type"`
+	Tick    *Tick    `json:"tick,omitempty"`
+	Symbols []string `json:"symbols,omitempty"`
+	Symbol  string   `json:"symbol,omitempty"`
+	Code    string   `json:"code,omitempty"`
+	Message string   `json:"message,omitempty"`
+}
+
+func wsError(symbol string, err error) WSMessage {
+	appErr := apperror.Classify(err)
+	return WSMessage{Type: "error", Symbol: symbol, Code: appErr.Code, Message: appErr.Message}
+}
+
+// Ticks upgrades to a WebSocket that pushes a tick every time a subscribed
+// coin is refreshed. A client whose queue fills up loses ticks or is
+// disconnected with a policy violation, depending on stream.overflow. The
+// connection is closed once the access token it was opened with expires.
+func (api *API) Ticks(w http.ResponseWriter, r *http.Request) {
+	principal, ok := currentPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader already replied
+	}
+	defer conn.Close()
+
+	disconnect := api.stream.Overflow == "disconnect"
+	sub, _ := api.ticks.subscribe(api.stream.QueueSize, disconnect, nil, 0)
+	defer func() {
+		dropped := api.ticks.unsubscribe(sub)
+		slog.DebugContext(r.Context(), "websocket closed", "dropped", dropped)
+	}()
+	slog.DebugContext(r.Context(), "websocket opened")
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	replies := make(chan WSMessage, 8)
+	go func() {
+		defer cancel()
+		api.readWS(ctx, conn, sub, replies)
+	}()
+
+	write := func(message any) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(message) == nil
+	}
+	closeWith := func(code int, reason error) {
+		deadline := time.Now().Add(wsWriteTimeout)
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason.Error()), deadline)
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	expired := time.NewTimer(time.Until(principal.ExpiresAt))
+	defer expired.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-api.streams.Done():
+			closeWith(websocket.CloseGoingAway, errors.New("server shutting down"))
+			return
+		case <-sub.gone:
+			closeWith(websocket.ClosePolicyViolation, errSlowConsumer)
+			return
+		case <-expired.C:
+			closeWith(websocket.ClosePolicyViolation, errConnectionExpired)
+			return
+		case <-ping.C:
+			deadline := time.Now().Add(wsWriteTimeout)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return
+			}
+		case reply := <-replies:
+			if !write(reply) {
+				return
+			}
+		case tick := <-sub.ticks:
+			if !write(WSMessage{Type: "tick", Tick: &tick}) {
+				return
+			}
+		}
+	}
+}
+
+// readWS handles subscribe/unsubscribe ops until the connection fails. It is
+// the only reader of conn; replies go through the writer loop.
+func (api *API) readWS(ctx context.Context, conn *websocket.Conn, sub *subscriber, replies chan<- WSMessage) {
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	reply := func(message WSMessage) bool {
+		select {
+		case replies <- message:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	symbols := make(map[string]string) // id -> symbol as the client wrote it
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				slog.DebugContext(ctx, "websocket read failed", "err", err)
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		request := WSRequest{}
+		if err := json.Unmarshal(data, &request); err != nil {
+			if !reply(wsError("", ErrInvalidJSON)) {
+				return
+			}
+			continue
+		}
+
+		switch request.Op {
+		case "subscribe":
+			for _, symbol := range request.Symbols {
+				id, err := api.getID(ctx, symbol)
+				if err != nil {
+					if !reply(wsError(symbol, err)) {
+						return
+					}
+					continue
+				}
+				if _, ok := symbols[id]; !ok && len(symbols) >= maxSubscriptions {
+					if !reply(wsError(symbol, ErrTooManySymbols)) {
+						return
+					}
+					continue
+				}
+				symbols[id] = symbol
+			}
+		case "unsubscribe":
+			for _, symbol := range request.Symbols {
+				for id, subscribed := range symbols {
+					if subscribed == symbol || id == symbol {
+						delete(symbols, id)
+					}
+				}
+			}
+		default:
+			if !reply(wsError("", ErrInvalidOp)) {
+				return
+			}
+			continue
+		}
+
+		ids := make(map[string]bool, len(symbols))
+		for id := range symbols {
+			ids[id] = true
+		}
+		api.ticks.follow(sub, ids)
+
+		subscribed := slices.Sorted(maps.Values(symbols))
+		if !reply(WSMessage{Type: "subscribed", Symbols: subscribed}) {
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"cryptoserver/clean/domain"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWS opens /ws as user with header added to the handshake.
+func dialWS(t *testing.T, ta *testAPI, user string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	server := httptest.NewServer(ta.handler)
+	t.Cleanup(server.Close)
+
+	if header == nil {
+		header = http.Header{}
+	}
+	if user != "" {
+		header.Set("X-User", user)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+func readWS(t *testing.T, conn *websocket.Conn) WSMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var message WSMessage
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	return message
+}
+
+func TestWSUnauthenticated(t *testing.T) {
+	ta := newTestAPI(t)
+	_, resp, err := dialWS(t, ta, "", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous dial: %v, want 401", err)
+	}
+}
+
+func TestWSSubscribe(t *testing.T) {
+	ta := newTestAPI(t)
+	ta.loadSymbols(t)
+	conn, _, err := dialWS(t, ta, "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(request any) {
+		t.Helper()
+		if err := conn.WriteJSON(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(want WSMessage) {
+		t.Helper()
+		got := readWS(t, conn)
+		if got.Type != want.Type || got.Symbol != want.Symbol || got.Code != want.Code || !slices.Equal(got.Symbols, want.Symbols) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	expectTick := func(id string, price float64) {
+		t.Helper()
+		got := readWS(t, conn)
+		if got.Type != "tick" || got.Tick == nil || got.Tick.ID != id || got.Tick.Price != price {
+			t.Errorf("got %+v, want a %s tick at %v", got, id, price)
+		}
+	}
+
+	send(WSRequest{Op: "subscribe", Symbols: []string{"btc", "doge", "sol"}})
+	expect(WSMessage{Type: "error", Symbol: "doge", Code: "symbol_not_found"})
+	expect(WSMessage{Type: "error", Symbol: "sol", Code: "ambiguous_symbol"})
+	expect(WSMessage{Type: "subscribed", Symbols: []string{"btc"}})
+
+	// only subscribed coins are pushed
+	ta.ticks.publish(&domain.Coin{ID: "ethereum", Symbol: "eth", CurrentPrice: 11})
+	ta.ticks.publish(&domain.Coin{ID: "bitcoin", Symbol: "btc", CurrentPrice: 101})
+	expectTick("bitcoin", 101)
+
+	send(WSRequest{Op: "subscribe", Symbols: []string{"eth"}})
+	expect(WSMessage{Type: "subscribed", Symbols: []string{"btc", "eth"}})
+	ta.ticks.publish(&domain.Coin{ID: "ethereum", Symbol: "eth", CurrentPrice: 12})
+	expectTick("ethereum", 12)
+
+	send(WSRequest{Op: "unsubscribe", Symbols: []string{"btc", "eth"}})
+	expect(WSMessage{Type: "subscribed"})
+
+	send(WSRequest{Op: "list"})
+	expect(WSMessage{Type: "error", Code: "invalid_op"})
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	expect(WSMessage{Type: "error", Code: "invalid_json"})
+
+	// the connection survives bad messages and nothing is followed any more
+	ta.ticks.publish(&domain.Coin{ID: "bitcoin", Symbol: "btc", CurrentPrice: 102})
+	send(WSRequest{Op: "subscribe", Symbols: []string{"eth"}})
+	expect(WSMessage{Type: "subscribed", Symbols: []string{"eth"}})
+}
+
+func TestWSTokenExpiry(t *testing.T) {
+	ta := newTestAPI(t)
+	conn, _, err := dialWS(t, ta, "alice", http.Header{"X-Expires-In": {"50ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != errConnectionExpired.Error() {
+		t.Errorf("read after expiry: %v, want a policy violation close", err)
+	}
+}
@@ -1,14 +1,36 @@
 package main
 
 import (
+	"context"
+	"cryptoserver/config"
+	"cryptoserver/logging"
 	"cryptoserver/rest"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	log.Println("Server started on port :8080")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if err := rest.CreateAndRun(); err != nil {
+	if err := logging.Setup(os.Stdout, cfg.Log.Level, cfg.Log.Format); err != nil {
 		log.Fatal(err)
 	}
+
+	// docker stop sends SIGTERM, Ctrl+C sends SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("server started", "addr", cfg.Server.Addr)
+
+	if err := rest.CreateAndRun(ctx, cfg); err != nil {
+		slog.Error("server failed", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("server stopped")
 }
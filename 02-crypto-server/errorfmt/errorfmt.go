@@ -1,19 +1,84 @@
 package errorfmt
 
 import (
+	"cryptoserver/apperror"
 	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
 )
 
-type errorJson struct {
-	Err string `json:"error"`
+var statuses = map[apperror.Kind]int{
+	apperror.Validation:   http.StatusBadRequest,
+	apperror.Unauthorized: http.StatusUnauthorized,
+	apperror.NotFound:     http.StatusNotFound,
+	apperror.Conflict:     http.StatusConflict,
+	apperror.RateLimited:  http.StatusTooManyRequests,
+	apperror.Upstream:     http.StatusBadGateway,
+	apperror.Internal:     http.StatusInternalServerError,
 }
 
-func newErrorJson(err string) errorJson {
-	return errorJson{Err: err}
+// Problem is an RFC 7807 body extended with the machine-readable code.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Code     string `json:"code"`
+	Instance string `json:"instance,omitempty"`
 }
 
-func Jsonize(err error) string {
-	errStruct := newErrorJson(err.Error())
-	errJson, _ := json.Marshal(errStruct)
-	return string(errJson)
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := apperror.Classify(err)
+	status, ok := statuses[appErr.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	if status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "request failed", "code", appErr.Code, "err", err)
+	}
+
+	if appErr.Kind == apperror.RateLimited && appErr.RetryAfter > 0 {
+		seconds := int(math.Ceil(appErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	}
+
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   appErr.Message,
+		Code:     appErr.Code,
+		Instance: r.URL.Path,
+	}
+	body, _ := json.Marshal(problem)
+	if len(appErr.Extensions) > 0 {
+		body = extend(body, appErr.Extensions)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// extend adds extension members to a marshalled Problem, without replacing
+// the standard ones.
+func extend(body []byte, extensions map[string]any) []byte {
+	members := make(map[string]any)
+	if err := json.Unmarshal(body, &members); err != nil {
+		return body
+	}
+	for key, value := range extensions {
+		if _, ok := members[key]; !ok {
+			members[key] = value
+		}
+	}
+	extended, err := json.Marshal(members)
+	if err != nil {
+		return body
+	}
+	return extended
 }
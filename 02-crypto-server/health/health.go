@@ -0,0 +1,162 @@
+package health
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"cryptoserver/crypto"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+const (
+	checkTimeout = 2 * time.Second
+	// upstreamTTL keeps probes from spending the shared upstream budget.
+	upstreamTTL = time.Minute
+)
+
+type Check struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"` // a fixed reason, never the error text
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Checker reports on the dependencies of the server. Redis is required to
+// serve anything, so its outage makes the server not ready. Upstream or
+// collector trouble only degrades it: cached data can still be served.
+type Checker struct {
+	cache    *redis.Client
+	upstream domain.MarketDataProvider
+	api      *crypto.API
+
+	mu           sync.Mutex
+	lastUpstream Check
+}
+
+func NewChecker(cache *redis.Client, upstream domain.MarketDataProvider, api *crypto.API) *Checker {
+	return &Checker{cache: cache, upstream: upstream, api: api}
+}
+
+// reason tells why a dependency failed without the error text, which names
+// internal hosts and ports and is served without auth.
+func reason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, domain.ErrLimitExceeded):
+		return "rate_limited"
+	default:
+		return "unreachable"
+	}
+}
+
+func (c *Checker) checkRedis(ctx context.Context) Check {
+	check := Check{Status: StatusOK, CheckedAt: time.Now()}
+	if err := c.cache.Ping(ctx).Err(); err != nil {
+		slog.WarnContext(ctx, "health check failed", "check", "redis", "err", err)
+		check.Status = StatusDown
+		check.Error = reason(err)
+	}
+	return check
+}
+
+func (c *Checker) checkUpstream(ctx context.Context) Check {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastUpstream.CheckedAt) < upstreamTTL {
+		return c.lastUpstream
+	}
+
+	check := Check{Status: StatusOK, CheckedAt: time.Now()}
+	if err := c.upstream.Ping(ctx); err != nil {
+		slog.WarnContext(ctx, "health check failed", "check", "upstream", "err", err)
+		check.Status = StatusDegraded
+		check.Error = reason(err)
+	}
+	c.lastUpstream = check
+	return check
+}
+
+func (c *Checker) checkCollector() Check {
+	status := c.api.Status()
+	check := Check{Status: StatusOK, CheckedAt: time.Now()}
+
+	switch {
+	case status.LastFinished.IsZero():
+		if !status.Running {
+			check.Status = StatusDegraded
+			check.Error = "collector has not run yet"
+		}
+	case !status.Running && time.Since(status.LastFinished) > 3*c.api.CollectorInterval():
+		check.Status = StatusDegraded
+		check.Error = "collector last finished at " + status.LastFinished.Format(time.RFC3339)
+	case status.Errors > 0:
+		check.Status = StatusDegraded
+		check.Error = "refresh failed"
+	}
+	return check
+}
+
+func (c *Checker) Report(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{
+		Status: StatusOK,
+		Checks: map[string]Check{
+			"redis":     c.checkRedis(ctx),
+			"upstream":  c.checkUpstream(ctx),
+			"collector": c.checkCollector(),
+		},
+	}
+
+	for _, check := range report.Checks {
+		if check.Status == StatusDown {
+			report.Status = StatusDown
+		} else if check.Status == StatusDegraded && report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func writeReport(w http.ResponseWriter, report Report, status int) {
+	clientJSON, _ := json.Marshal(report)
+	w.WriteHeader(status)
+	w.Write(clientJSON)
+}
+
+// Healthz is the liveness probe: the process is up and able to answer, the
+// report is informational.
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, c.Report(r.Context()), http.StatusOK)
+}
+
+// Readyz is the readiness probe: 503 while a required dependency is down.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := c.Report(r.Context())
+	status := http.StatusOK
+	if report.Status == StatusDown {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, report, status)
+}
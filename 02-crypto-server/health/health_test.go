@@ -0,0 +1,100 @@
+package health
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"cryptoserver/provider"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// failingProvider is a Fake whose Ping fails with err.
+type failingProvider struct {
+	*provider.Fake
+	err error
+}
+
+func (p failingProvider) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestChecksHideErrors(t *testing.T) {
+	// a port nobody listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cache := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+	defer cache.Close()
+
+	upstreamErr := fmt.Errorf("Get \"http://coingecko.internal:8443/ping\": %w", errors.New("connection refused"))
+	tests := []struct {
+		name       string
+		check      func(c *Checker) Check
+		wantStatus string
+		wantError  string
+	}{
+		{
+			name:       "redis down",
+			check:      func(c *Checker) Check { return c.checkRedis(context.Background()) },
+			wantStatus: StatusDown,
+			wantError:  "unreachable",
+		},
+		{
+			name: "upstream down",
+			check: func(c *Checker) Check {
+				c.upstream = failingProvider{provider.NewFake(), upstreamErr}
+				return c.checkUpstream(context.Background())
+			},
+			wantStatus: StatusDegraded,
+			wantError:  "unreachable",
+		},
+		{
+			name: "upstream timeout",
+			check: func(c *Checker) Check {
+				c.upstream = failingProvider{provider.NewFake(), fmt.Errorf("ping: %w", context.DeadlineExceeded)}
+				return c.checkUpstream(context.Background())
+			},
+			wantStatus: StatusDegraded,
+			wantError:  "timeout",
+		},
+		{
+			name: "upstream rate limited",
+			check: func(c *Checker) Check {
+				c.upstream = failingProvider{provider.NewFake(), domain.LimitExceeded(time.Minute)}
+				return c.checkUpstream(context.Background())
+			},
+			wantStatus: StatusDegraded,
+			wantError:  "rate_limited",
+		},
+		{
+			name: "upstream up",
+			check: func(c *Checker) Check {
+				c.upstream = provider.NewFake()
+				return c.checkUpstream(context.Background())
+			},
+			wantStatus: StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := tt.check(NewChecker(cache, nil, nil))
+			if check.Status != tt.wantStatus || check.Error != tt.wantError {
+				t.Errorf("check = %s %q, want %s %q", check.Status, check.Error, tt.wantStatus, tt.wantError)
+			}
+			if strings.Contains(check.Error, addr) || strings.Contains(check.Error, "internal") {
+				t.Errorf("check error %q leaks the address", check.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package lru
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache is a bounded in-process cache. Entries expire after ttl and the least
+// recently used ones are evicted once their total weight exceeds capacity.
+// Expired entries are dropped on lookup or by Sweep.
+type Cache[V any] struct {
+	mu       sync.Mutex
+	capacity int
+	used     int
+	ttl      time.Duration
+	weigh    func(key string, value V) int
+	items    map[string]*list.Element
+	order    *list.List // most recently used first
+}
+
+type item[V any] struct {
+	key     string
+	value   V
+	weight  int
+	expires time.Time
+}
+
+// New bounds the cache by the summed weigh of its entries, a nil weigh
+// counts every entry as 1.
+func New[V any](capacity int, ttl time.Duration, weigh func(key string, value V) int) *Cache[V] {
+	if weigh == nil {
+		weigh = func(string, V) int { return 1 }
+	}
+	return &Cache[V]{
+		capacity: capacity,
+		ttl:      ttl,
+		weigh:    weigh,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *Cache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	it := el.Value.(*item[V])
+	if time.Now().After(it.expires) {
+		c.remove(el)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return it.value, true
+}
+
+// Set stores value under key. A value heavier than the whole capacity is not
+// kept.
+func (c *Cache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	weight := c.weigh(key, value)
+	if weight > c.capacity {
+		return
+	}
+
+	it := &item[V]{key: key, value: value, weight: weight, expires: time.Now().Add(c.ttl)}
+	c.items[key] = c.order.PushFront(it)
+	c.used += weight
+	for c.used > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *Cache[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Sweep drops every expired entry.
+func (c *Cache[V]) Sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if now.After(el.Value.(*item[V]).expires) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// Weight is the summed weight of the entries, expired ones included.
+func (c *Cache[V]) Weight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+func (c *Cache[V]) remove(el *list.Element) {
+	it := el.Value.(*item[V])
+	c.order.Remove(el)
+	delete(c.items, it.key)
+	c.used -= it.weight
+}
@@ -0,0 +1,142 @@
+package lru
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	byLength := func(key string, value string) int { return len(value) }
+
+	tests := []struct {
+		name       string
+		capacity   int
+		weigh      func(key string, value string) int
+		ops        func(c *Cache[string])
+		want       map[string]string // key -> value, "" for absent
+		wantWeight int
+	}{
+		{
+			name:     "get and set",
+			capacity: 2,
+			ops: func(c *Cache[string]) {
+				c.Set("a", "1")
+				c.Set("b", "2")
+				c.Set("a", "3")
+			},
+			want:       map[string]string{"a": "3", "b": "2"},
+			wantWeight: 2,
+		},
+		{
+			name:     "evicts the least recently used",
+			capacity: 2,
+			ops: func(c *Cache[string]) {
+				c.Set("a", "1")
+				c.Set("b", "2")
+				c.Get("a")
+				c.Set("c", "3")
+			},
+			want:       map[string]string{"a": "1", "b": "", "c": "3"},
+			wantWeight: 2,
+		},
+		{
+			name:     "delete",
+			capacity: 2,
+			ops: func(c *Cache[string]) {
+				c.Set("a", "1")
+				c.Delete("a")
+			},
+			want: map[string]string{"a": ""},
+		},
+		{
+			name:     "bounded by weight",
+			capacity: 10,
+			weigh:    byLength,
+			ops: func(c *Cache[string]) {
+				c.Set("a", "aaaa")
+				c.Set("b", "bbbb")
+				c.Set("c", "cccc")
+			},
+			want:       map[string]string{"a": "", "b": "bbbb", "c": "cccc"},
+			wantWeight: 8,
+		},
+		{
+			name:     "replacing reweighs",
+			capacity: 10,
+			weigh:    byLength,
+			ops: func(c *Cache[string]) {
+				c.Set("a", "aaaaaaaa")
+				c.Set("a", "a")
+				c.Set("b", "bbbbbbbb")
+			},
+			want:       map[string]string{"a": "a", "b": "bbbbbbbb"},
+			wantWeight: 9,
+		},
+		{
+			name:     "value heavier than capacity is not kept",
+			capacity: 4,
+			weigh:    byLength,
+			ops: func(c *Cache[string]) {
+				c.Set("a", "aa")
+				c.Set("b", "bbbbb")
+			},
+			want:       map[string]string{"a": "aa", "b": ""},
+			wantWeight: 2,
+		},
+		{
+			name:     "oversized value drops the old one",
+			capacity: 4,
+			weigh:    byLength,
+			ops: func(c *Cache[string]) {
+				c.Set("a", "aa")
+				c.Set("a", "aaaaa")
+			},
+			want: map[string]string{"a": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(tt.capacity, time.Hour, tt.weigh)
+			tt.ops(c)
+			if got := c.Weight(); got != tt.wantWeight {
+				t.Errorf("weight = %d, want %d", got, tt.wantWeight)
+			}
+			for key, want := range tt.want {
+				got, ok := c.Get(key)
+				if ok != (want != "") || got != want {
+					t.Errorf("Get(%q) = %q, %v, want %q", key, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	c := New[string](10, 20*time.Millisecond, nil)
+	c.Set("old", "1")
+	time.Sleep(30 * time.Millisecond)
+	c.Set("new", "2")
+
+	if c.Weight() != 2 {
+		t.Fatalf("weight = %d, want expired entries counted until swept", c.Weight())
+	}
+	c.Sweep()
+	if c.Weight() != 1 {
+		t.Errorf("weight after Sweep = %d, want 1", c.Weight())
+	}
+	if _, ok := c.Get("old"); ok {
+		t.Error("expired entry was served")
+	}
+	if v, ok := c.Get("new"); !ok || v != "2" {
+		t.Errorf("Get(new) = %q, %v, want 2", v, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("new"); ok {
+		t.Error("expired entry was served")
+	}
+	if c.Weight() != 0 {
+		t.Errorf("weight = %d, want an expired lookup to drop the entry", c.Weight())
+	}
+}
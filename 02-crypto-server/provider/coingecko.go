@@ -0,0 +1,230 @@
+package provider
+
+import (
+	"context"
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"cryptoserver/config"
+	"cryptoserver/metrics"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type cryptoDTO struct {
+	Id     string `json:"id"`
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+}
+
+type cryptoDTOList struct {
+	Coins []cryptoDTO `json:"coins"`
+}
+
+type coinDTO struct {
+	Symbol     string `json:"symbol"`
+	Name       string `json:"name"`
+	MarketData struct {
+		CurrentPrice map[string]float64 `json:"current_price"`
+	} `json:"market_data"`
+	LastUpdated string `json:"last_updated"`
+}
+
+type historyDTO struct {
+	Prices [][]float64 `json:"prices"`
+}
+
+type marketDTO struct {
+	Id           string  `json:"id"`
+	Symbol       string  `json:"symbol"`
+	Name         string  `json:"name"`
+	CurrentPrice float64 `json:"current_price"`
+	LastUpdated  string  `json:"last_updated"`
+}
+
+type CoinGecko struct {
+	rootURL string
+	key     string
+	client  *http.Client
+}
+
+// upstreamError hides the provider URL and response from clients, the cause
+// is only logged.
+func upstreamError(code string, err error) error {
+	return &apperror.Error{
+		Kind:    apperror.Upstream,
+		Code:    code,
+		Message: "Market data provider is unavailable.",
+		Err:     err,
+	}
+}
+
+func NewCoinGecko(cfg config.CoinGecko) *CoinGecko {
+	return &CoinGecko{
+		rootURL: strings.TrimSuffix(cfg.RootURL, "/"),
+		key:     cfg.APIKey,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+		},
+	}
+}
+
+// get fetches path and decodes the JSON body into dst. endpoint names the
+// call in metrics.
+func (cg *CoinGecko) get(ctx context.Context, endpoint, path string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cg.rootURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("x-cg-demo-api-key", cg.key)
+
+	start := time.Now()
+	resp, err := cg.client.Do(req)
+	if err != nil {
+		metrics.ObserveUpstream(endpoint, 0, time.Since(start))
+		return upstreamError("upstream_unavailable", err)
+	}
+	defer resp.Body.Close()
+	metrics.ObserveUpstream(endpoint, resp.StatusCode, time.Since(start))
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := time.Minute
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return domain.LimitExceeded(retryAfter)
+	} else if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("coingecko: unexpected status %d", resp.StatusCode)
+		return upstreamError("upstream_status", err)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+		return upstreamError("upstream_bad_response", err)
+	}
+	return nil
+}
+
+func (cg *CoinGecko) Ping(ctx context.Context) error {
+	var pong struct {
+		GeckoSays string `json:"gecko_says"`
+	}
+	return cg.get(ctx, "ping", "/ping", &pong)
+}
+
+func (cg *CoinGecko) SearchSymbol(ctx context.Context, symbol string) (string, error) {
+	cryptos := cryptoDTOList{}
+	if err := cg.get(ctx, "search", "/search?query="+url.QueryEscape(symbol), &cryptos); err != nil {
+		return "", err
+	}
+
+	// a canonical id wins over a ticker, as it does in the symbol index
+	for _, crypto := range cryptos.Coins {
+		if crypto.Id == strings.ToLower(symbol) {
+			return crypto.Id, nil
+		}
+	}
+	for _, crypto := range cryptos.Coins {
+		if strings.EqualFold(crypto.Symbol, symbol) {
+			return crypto.Id, nil
+		}
+	}
+
+	return "", domain.ErrNoID
+}
+
+func (cg *CoinGecko) ListCoins(ctx context.Context) ([]domain.CoinListing, error) {
+	cryptos := []cryptoDTO{}
+	if err := cg.get(ctx, "list", "/coins/list", &cryptos); err != nil {
+		return nil, err
+	}
+
+	coins := make([]domain.CoinListing, len(cryptos))
+	for i, crypto := range cryptos {
+		coins[i] = domain.CoinListing{ID: crypto.Id, Symbol: crypto.Symbol, Name: crypto.Name}
+	}
+	return coins, nil
+}
+
+func (cg *CoinGecko) GetCoin(ctx context.Context, id string) (*domain.Coin, error) {
+	coin := coinDTO{}
+	if err := cg.get(ctx, "coin", "/coins/"+url.PathEscape(id), &coin); err != nil {
+		return nil, err
+	}
+
+	if coin.Symbol == "" && coin.Name == "" {
+		return nil, domain.ErrLimitExceeded
+	}
+
+	prices := make(map[string]float64, len(domain.Currencies))
+	for currency, price := range coin.MarketData.CurrentPrice {
+		if domain.Currencies[currency] {
+			prices[currency] = price
+		}
+	}
+
+	return &domain.Coin{
+		ID:           id,
+		Symbol:       coin.Symbol,
+		Name:         coin.Name,
+		CurrentPrice: prices[domain.DefaultCurrency],
+		Prices:       prices,
+		LastUpdated:  coin.LastUpdated,
+	}, nil
+}
+
+// GetQuotes prices up to 250 coins, the page size of coins/markets, in one
+// call.
+func (cg *CoinGecko) GetQuotes(ctx context.Context, ids []string, currency string) ([]domain.Coin, error) {
+	path := fmt.Sprintf("/coins/markets?vs_currency=%s&ids=%s&per_page=250",
+		url.QueryEscape(currency), url.QueryEscape(strings.Join(ids, ",")))
+	markets := []marketDTO{}
+	if err := cg.get(ctx, "markets", path, &markets); err != nil {
+		return nil, err
+	}
+
+	coins := make([]domain.Coin, 0, len(markets))
+	for _, market := range markets {
+		coin := domain.Coin{
+			ID:          market.Id,
+			Symbol:      market.Symbol,
+			Name:        market.Name,
+			Prices:      map[string]float64{currency: market.CurrentPrice},
+			LastUpdated: market.LastUpdated,
+		}
+		if currency == domain.DefaultCurrency {
+			coin.CurrentPrice = market.CurrentPrice
+		}
+		coins = append(coins, coin)
+	}
+	return coins, nil
+}
+
+// GetHistory returns the prices between from and to. CoinGecko picks the
+// granularity from the length of the range: 5 minutes up to a day, hourly up
+// to 90 days and daily beyond that.
+func (cg *CoinGecko) GetHistory(ctx context.Context, id, currency string, from, to time.Time) ([]domain.PricePoint, error) {
+	path := fmt.Sprintf("/coins/%s/market_chart/range?vs_currency=%s&from=%d&to=%d",
+		url.PathEscape(id), url.QueryEscape(currency), from.Unix(), to.Unix())
+	history := historyDTO{}
+	if err := cg.get(ctx, "market_chart", path, &history); err != nil {
+		return nil, err
+	}
+
+	points := make([]domain.PricePoint, 0, len(history.Prices))
+	for _, obj := range history.Prices {
+		if len(obj) < 2 {
+			continue
+		}
+		price, ms := obj[1], int64(obj[0])
+		points = append(points, domain.PricePoint{
+			Price:     price,
+			Timestamp: time.UnixMilli(ms).UTC(),
+		})
+	}
+
+	return points, nil
+}
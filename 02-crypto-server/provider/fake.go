@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is an in-process MarketDataProvider for tests and local runs without
+// network access. Coins are registered with Add, their history is in
+// DefaultCurrency and converted at the coin's current rates.
+type Fake struct {
+	mu      sync.RWMutex
+	coins   map[string]domain.Coin // id -> coin
+	history map[string][]domain.PricePoint
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		coins:   make(map[string]domain.Coin),
+		history: make(map[string][]domain.PricePoint),
+	}
+}
+
+func (f *Fake) Add(coin domain.Coin, history []domain.PricePoint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.coins[coin.ID] = coin
+	f.history[coin.ID] = history
+}
+
+func (f *Fake) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (f *Fake) SearchSymbol(ctx context.Context, symbol string) (string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if _, ok := f.coins[strings.ToLower(symbol)]; ok {
+		return strings.ToLower(symbol), nil
+	}
+	for id, coin := range f.coins {
+		if strings.EqualFold(coin.Symbol, symbol) {
+			return id, nil
+		}
+	}
+	return "", domain.ErrNoID
+}
+
+func (f *Fake) ListCoins(ctx context.Context) ([]domain.CoinListing, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	coins := make([]domain.CoinListing, 0, len(f.coins))
+	for id, coin := range f.coins {
+		coins = append(coins, domain.CoinListing{ID: id, Symbol: coin.Symbol, Name: coin.Name})
+	}
+	// ordered by id like the upstream list, so results do not depend on map order
+	slices.SortFunc(coins, func(a, b domain.CoinListing) int { return strings.Compare(a.ID, b.ID) })
+	return coins, nil
+}
+
+func (f *Fake) GetCoin(ctx context.Context, id string) (*domain.Coin, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	coin, ok := f.coins[id]
+	if !ok {
+		return nil, domain.ErrNoID
+	}
+	return &coin, nil
+}
+
+func (f *Fake) GetQuotes(ctx context.Context, ids []string, currency string) ([]domain.Coin, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	coins := make([]domain.Coin, 0, len(ids))
+	for _, id := range ids {
+		if coin, ok := f.coins[id]; ok {
+			coins = append(coins, coin)
+		}
+	}
+	return coins, nil
+}
+
+func (f *Fake) GetHistory(ctx context.Context, id, currency string, from, to time.Time) ([]domain.PricePoint, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	coin, ok := f.coins[id]
+	if !ok {
+		return nil, domain.ErrNoID
+	}
+
+	rate := 1.0
+	if currency != domain.DefaultCurrency {
+		price, ok := coin.Price(currency)
+		if !ok || coin.CurrentPrice == 0 {
+			return nil, domain.ErrNoPrice
+		}
+		rate = price / coin.CurrentPrice
+	}
+
+	history := make([]domain.PricePoint, 0, len(f.history[id]))
+	for _, point := range f.history[id] {
+		if point.Timestamp.Before(from) || point.Timestamp.After(to) {
+			continue
+		}
+		history = append(history, domain.PricePoint{Price: point.Price * rate, Timestamp: point.Timestamp})
+	}
+	return history, nil
+}
@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"cryptoserver/config"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+)
+
+// Throttled wraps a provider with a token bucket shared by every caller and
+// coalesces identical in-flight requests, so concurrent lookups of one coin
+// cost a single upstream call. When the bucket is empty calls fail fast with
+// domain.ErrLimitExceeded instead of queueing.
+type Throttled struct {
+	inner   domain.MarketDataProvider
+	limiter *rate.Limiter
+	group   singleflight.Group
+}
+
+func NewThrottled(inner domain.MarketDataProvider, cfg config.CoinGecko) *Throttled {
+	every := time.Minute / time.Duration(cfg.RequestsPerMinute)
+	return &Throttled{
+		inner:   inner,
+		limiter: rate.NewLimiter(rate.Every(every), cfg.Burst),
+	}
+}
+
+func (t *Throttled) take() error {
+	reservation := t.limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return domain.LimitExceeded(delay)
+	}
+	return nil
+}
+
+// do runs fn once per key at a time. The shared call is detached from the
+// caller's cancellation so one impatient client can't fail the others.
+func do[T any](t *Throttled, ctx context.Context, key string, fn func(context.Context) (T, error)) (T, error) {
+	v, err, _ := t.group.Do(key, func() (any, error) {
+		if err := t.take(); err != nil {
+			return nil, err
+		}
+		return fn(context.WithoutCancel(ctx))
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return v.(T), nil
+}
+
+func (t *Throttled) Ping(ctx context.Context) error {
+	_, err := do(t, ctx, "ping", func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.inner.Ping(ctx)
+	})
+	return err
+}
+
+func (t *Throttled) SearchSymbol(ctx context.Context, symbol string) (string, error) {
+	return do(t, ctx, "search:"+symbol, func(ctx context.Context) (string, error) {
+		return t.inner.SearchSymbol(ctx, symbol)
+	})
+}
+
+func (t *Throttled) ListCoins(ctx context.Context) ([]domain.CoinListing, error) {
+	return do(t, ctx, "list", func(ctx context.Context) ([]domain.CoinListing, error) {
+		return t.inner.ListCoins(ctx)
+	})
+}
+
+func (t *Throttled) GetCoin(ctx context.Context, id string) (*domain.Coin, error) {
+	return do(t, ctx, "coin:"+id, func(ctx context.Context) (*domain.Coin, error) {
+		return t.inner.GetCoin(ctx, id)
+	})
+}
+
+func (t *Throttled) GetQuotes(ctx context.Context, ids []string, currency string) ([]domain.Coin, error) {
+	key := "quotes:" + currency + ":" + strings.Join(slices.Sorted(slices.Values(ids)), ",")
+	return do(t, ctx, key, func(ctx context.Context) ([]domain.Coin, error) {
+		return t.inner.GetQuotes(ctx, ids, currency)
+	})
+}
+
+func (t *Throttled) GetHistory(ctx context.Context, id, currency string, from, to time.Time) ([]domain.PricePoint, error) {
+	key := fmt.Sprintf("history:%s:%s:%d:%d", id, currency, from.Unix(), to.Unix())
+	return do(t, ctx, key, func(ctx context.Context) ([]domain.PricePoint, error) {
+		return t.inner.GetHistory(ctx, id, currency, from, to)
+	})
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	alertRulePrefix   = "alert:rule:"
+	alertUserPrefix   = "alert:user:"   // set of rule ids
+	alertCoinPrefix   = "alert:coin:"   // set of rule ids
+	alertEventsPrefix = "alert:events:" // list of events, newest first
+
+	maxAlertEvents = 100
+)
+
+// RedisAlerts keeps every rule as a JSON string indexed by owner and by coin.
+// Only the latest maxAlertEvents events are kept per user.
+type RedisAlerts struct {
+	ctx   context.Context
+	cache *redis.Client
+}
+
+func NewRedisAlerts(cache *redis.Client) *RedisAlerts {
+	return &RedisAlerts{ctx: context.Background(), cache: cache}
+}
+
+func (r *RedisAlerts) SaveRule(rule *domain.AlertRule) error {
+	ruleJSON, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.cache.TxPipeline()
+	pipe.Set(r.ctx, alertRulePrefix+rule.ID, ruleJSON, 0)
+	pipe.SAdd(r.ctx, alertUserPrefix+rule.UserID, rule.ID)
+	pipe.SAdd(r.ctx, alertCoinPrefix+rule.CoinID, rule.ID)
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisAlerts) UpdateRule(rule *domain.AlertRule) error {
+	ruleJSON, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	err = r.cache.SetArgs(r.ctx, alertRulePrefix+rule.ID, ruleJSON, redis.SetArgs{Mode: "XX"}).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+func (r *RedisAlerts) getRule(id string) (*domain.AlertRule, error) {
+	ruleJSON, err := r.cache.Get(r.ctx, alertRulePrefix+id).Result()
+	if err != nil {
+		return nil, err
+	}
+	rule := &domain.AlertRule{}
+	if err := json.Unmarshal([]byte(ruleJSON), rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (r *RedisAlerts) DeleteRule(userID, ruleID string) (bool, error) {
+	rule, err := r.getRule(ruleID)
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if rule.UserID != userID {
+		return false, nil
+	}
+
+	pipe := r.cache.TxPipeline()
+	pipe.Del(r.ctx, alertRulePrefix+ruleID)
+	pipe.SRem(r.ctx, alertUserPrefix+userID, ruleID)
+	pipe.SRem(r.ctx, alertCoinPrefix+rule.CoinID, ruleID)
+	_, err = pipe.Exec(r.ctx)
+	return err == nil, err
+}
+
+func (r *RedisAlerts) rules(indexKey string) ([]domain.AlertRule, error) {
+	ids, err := r.cache.SMembers(r.ctx, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]domain.AlertRule, 0, len(ids))
+	for _, id := range ids {
+		rule, err := r.getRule(id)
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				slog.Error("alert rule lookup failed", "rule", id, "err", err)
+			}
+			continue
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, nil
+}
+
+func (r *RedisAlerts) Rules(userID string) ([]domain.AlertRule, error) {
+	return r.rules(alertUserPrefix + userID)
+}
+
+func (r *RedisAlerts) CoinRules(coinID string) ([]domain.AlertRule, error) {
+	return r.rules(alertCoinPrefix + coinID)
+}
+
+func (r *RedisAlerts) Coins() ([]string, error) {
+	const keysPerRequest = 100
+	coins := make([]string, 0)
+	iter := r.cache.Scan(r.ctx, 0, alertCoinPrefix+"*", keysPerRequest).Iterator()
+	for iter.Next(r.ctx) {
+		coins = append(coins, iter.Val()[len(alertCoinPrefix):])
+	}
+	return coins, iter.Err()
+}
+
+func (r *RedisAlerts) AddEvent(event *domain.AlertEvent) error {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	key := alertEventsPrefix + event.UserID
+	pipe := r.cache.TxPipeline()
+	pipe.LPush(r.ctx, key, eventJSON)
+	pipe.LTrim(r.ctx, key, 0, maxAlertEvents-1)
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisAlerts) Events(userID string, limit int) ([]domain.AlertEvent, error) {
+	members, err := r.cache.LRange(r.ctx, alertEventsPrefix+userID, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]domain.AlertEvent, 0, len(members))
+	for _, member := range members {
+		event := domain.AlertEvent{}
+		if err := json.Unmarshal([]byte(member), &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
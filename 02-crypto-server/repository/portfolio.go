@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	portfolioPrefix = "portfolio:" // hash of transaction id -> transaction
+	// portfolioRetries bounds how often a change that lost a race is retried.
+	portfolioRetries = 10
+)
+
+// RedisPortfolio keeps a hash of transactions per user.
+type RedisPortfolio struct {
+	ctx   context.Context
+	cache *redis.Client
+}
+
+func NewRedisPortfolio(cache *redis.Client) *RedisPortfolio {
+	return &RedisPortfolio{ctx: context.Background(), cache: cache}
+}
+
+// update checks the transactions of userID and writes the change in a
+// WATCH/MULTI transaction, so two changes never pass check against the same
+// transactions. A change that lost the race is checked again.
+func (r *RedisPortfolio) update(userID string, check func(txs []domain.Transaction) error, write func(pipe redis.Pipeliner)) error {
+	key := portfolioPrefix + userID
+	for range portfolioRetries {
+		err := r.cache.Watch(r.ctx, func(rtx *redis.Tx) error {
+			txs, err := r.transactions(rtx, userID)
+			if err != nil {
+				return err
+			}
+			if err := check(txs); err != nil {
+				return err
+			}
+			_, err = rtx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+				write(pipe)
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return domain.ErrConcurrentUpdate
+}
+
+func (r *RedisPortfolio) AddTransaction(tx *domain.Transaction, check func(txs []domain.Transaction) error) error {
+	txJSON, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+
+	return r.update(tx.UserID, func(txs []domain.Transaction) error {
+		txs = append(txs, *tx)
+		slices.SortFunc(txs, domain.CompareTransactions)
+		return check(txs)
+	}, func(pipe redis.Pipeliner) {
+		pipe.HSet(r.ctx, portfolioPrefix+tx.UserID, tx.ID, txJSON)
+	})
+}
+
+func (r *RedisPortfolio) DeleteTransaction(userID, id string, check func(txs []domain.Transaction) error) (bool, error) {
+	found := false
+	err := r.update(userID, func(txs []domain.Transaction) error {
+		remaining := slices.DeleteFunc(slices.Clone(txs), func(tx domain.Transaction) bool {
+			return tx.ID == id
+		})
+		found = len(remaining) < len(txs)
+		if !found {
+			return nil
+		}
+		return check(remaining)
+	}, func(pipe redis.Pipeliner) {
+		if found {
+			pipe.HDel(r.ctx, portfolioPrefix+userID, id)
+		}
+	})
+	return found && err == nil, err
+}
+
+func (r *RedisPortfolio) Transactions(userID string) ([]domain.Transaction, error) {
+	return r.transactions(r.cache, userID)
+}
+
+func (r *RedisPortfolio) transactions(c redis.Cmdable, userID string) ([]domain.Transaction, error) {
+	members, err := c.HVals(r.ctx, portfolioPrefix+userID).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	txs := make([]domain.Transaction, 0, len(members))
+	for _, member := range members {
+		tx := domain.Transaction{}
+		if err := json.Unmarshal([]byte(member), &tx); err != nil {
+			slog.Error("portfolio transaction is corrupted", "user", userID, "err", err)
+			continue
+		}
+		txs = append(txs, tx)
+	}
+
+	slices.SortFunc(txs, domain.CompareTransactions)
+	return txs, nil
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"cryptoserver/clean/domain"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var errTooMany = errors.New("too many transactions")
+
+// atMost fails a check once there are more than n transactions.
+func atMost(n int) func(txs []domain.Transaction) error {
+	return func(txs []domain.Transaction) error {
+		if len(txs) > n {
+			return errTooMany
+		}
+		return nil
+	}
+}
+
+func newTestPortfolio(t *testing.T) *RedisPortfolio {
+	t.Helper()
+	cache := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { cache.Close() })
+	return NewRedisPortfolio(cache)
+}
+
+func trade(id string, minutes int) *domain.Transaction {
+	at := time.Date(2026, 1, 1, 0, minutes, 0, 0, time.UTC)
+	return &domain.Transaction{ID: id, UserID: "alice", CoinID: "bitcoin", Side: domain.TradeBuy, Quantity: 1, Timestamp: at, CreatedAt: at}
+}
+
+func TestPortfolioChecksTheResult(t *testing.T) {
+	p := newTestPortfolio(t)
+
+	var checked []string
+	record := func(txs []domain.Transaction) error {
+		checked = checked[:0]
+		for _, tx := range txs {
+			checked = append(checked, tx.ID)
+		}
+		return nil
+	}
+
+	p.AddTransaction(trade("b", 2), record)
+	p.AddTransaction(trade("a", 1), record)
+	if len(checked) != 2 || checked[0] != "a" || checked[1] != "b" {
+		t.Errorf("add checked %v, want [a b]", checked)
+	}
+
+	if err := p.AddTransaction(trade("c", 3), atMost(2)); !errors.Is(err, errTooMany) {
+		t.Errorf("add failing its check: err = %v", err)
+	}
+	if found, err := p.DeleteTransaction("alice", "a", record); !found || err != nil {
+		t.Errorf("delete = %v, %v", found, err)
+	}
+	if len(checked) != 1 || checked[0] != "b" {
+		t.Errorf("delete checked %v, want [b]", checked)
+	}
+	if found, err := p.DeleteTransaction("alice", "a", record); found || err != nil {
+		t.Errorf("deleting a missing transaction = %v, %v, want false", found, err)
+	}
+
+	txs, _ := p.Transactions("alice")
+	if len(txs) != 1 || txs[0].ID != "b" {
+		t.Errorf("transactions = %+v, want only b", txs)
+	}
+}
+
+func TestPortfolioRechecksAfterARace(t *testing.T) {
+	p := newTestPortfolio(t)
+
+	// another change lands between reading and writing the first attempt
+	calls := 0
+	racing := func(txs []domain.Transaction) error {
+		calls++
+		if calls == 1 {
+			if err := p.AddTransaction(trade("other", 1), atMost(1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return atMost(1)(txs)
+	}
+
+	if err := p.AddTransaction(trade("mine", 2), racing); !errors.Is(err, errTooMany) {
+		t.Errorf("err = %v, want the check to fail against the racing change", err)
+	}
+	if calls != 2 {
+		t.Errorf("check ran %d times, want 2", calls)
+	}
+	txs, _ := p.Transactions("alice")
+	if len(txs) != 1 || txs[0].ID != "other" {
+		t.Errorf("transactions = %+v, want only the racing one", txs)
+	}
+}
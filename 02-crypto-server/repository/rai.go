@@ -2,25 +2,62 @@ package repository
 
 import (
 	"cryptoserver/clean/domain"
+	"sync"
 )
 
 type Rai struct {
-	storage map[string]string // username -> hash
+	mu      sync.RWMutex
+	storage map[string]domain.User // username -> user
 }
 
 func NewRai() *Rai {
-	return &Rai{storage: make(map[string]string)}
+	return &Rai{storage: make(map[string]domain.User)}
 }
 
 func (r *Rai) Save(user *domain.User) error {
-	r.storage[user.Username] = user.PasswordHash
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.storage[user.Username]; ok {
+		return domain.ErrUserAlreadyExists
+	}
+	r.storage[user.Username] = *user
+	return nil
+}
+
+func (r *Rai) Find(id string) *domain.User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.storage {
+		if user.ID == id {
+			return &user
+		}
+	}
 	return nil
 }
 
+func (r *Rai) SetCurrency(id, currency string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for username, user := range r.storage {
+		if user.ID == id {
+			user.Currency = currency
+			r.storage[username] = user
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (r *Rai) Exist(username string) *domain.User {
-	hash, ok := r.storage[username]
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.storage[username]
 	if !ok {
 		return nil
 	}
-	return &domain.User{Username: username, PasswordHash: hash}
+	return &user
 }
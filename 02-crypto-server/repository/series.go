@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const seriesPrefix = "series:"
+
+// RedisSeries keeps sampled prices in one sorted set per coin, scored by the
+// sample time in milliseconds. Samples older than retention are trimmed on
+// every append.
+type RedisSeries struct {
+	ctx       context.Context
+	cache     *redis.Client
+	retention time.Duration
+}
+
+func NewRedisSeries(cache *redis.Client, retention time.Duration) *RedisSeries {
+	return &RedisSeries{ctx: context.Background(), cache: cache, retention: retention}
+}
+
+func (r *RedisSeries) Append(id string, point domain.PricePoint) error {
+	key := seriesPrefix + id
+	ms := point.Timestamp.UnixMilli()
+	member := strconv.FormatInt(ms, 10) + ":" + strconv.FormatFloat(point.Price, 'f', -1, 64)
+
+	pipe := r.cache.TxPipeline()
+	pipe.ZAdd(r.ctx, key, redis.Z{Score: float64(ms), Member: member})
+	cutoff := time.Now().Add(-r.retention).UnixMilli()
+	pipe.ZRemRangeByScore(r.ctx, key, "-inf", "("+strconv.FormatInt(cutoff, 10))
+	pipe.Expire(r.ctx, key, r.retention)
+	_, err := pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisSeries) Range(id string, from, to time.Time) ([]domain.PricePoint, error) {
+	members, err := r.cache.ZRangeByScore(r.ctx, seriesPrefix+id, &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.UnixMilli(), 10),
+		Max: strconv.FormatInt(to.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]domain.PricePoint, 0, len(members))
+	for _, member := range members {
+		msString, priceString, ok := strings.Cut(member, ":")
+		if !ok {
+			continue
+		}
+		ms, err := strconv.ParseInt(msString, 10, 64)
+		if err != nil {
+			continue
+		}
+		price, err := strconv.ParseFloat(priceString, 64)
+		if err != nil {
+			continue
+		}
+		points = append(points, domain.PricePoint{Price: price, Timestamp: time.UnixMilli(ms).UTC()})
+	}
+
+	return points, nil
+}
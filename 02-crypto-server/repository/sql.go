@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"cryptoserver/clean/domain"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type SQL struct {
+	db *sql.DB
+}
+
+func NewSQLite(path string) (*SQL, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQL{db: db}, nil
+}
+
+func (r *SQL) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQL) Save(user *domain.User) error {
+	_, err := r.db.Exec(`INSERT INTO users (user_id, username, password_hash, currency) VALUES (?, ?, ?, ?)`,
+		user.ID, user.Username, user.PasswordHash, user.Currency)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return domain.ErrUserAlreadyExists
+	}
+	return err
+}
+
+func (r *SQL) findBy(column, value string) *domain.User {
+	user := &domain.User{}
+	row := r.db.QueryRow(`SELECT user_id, username, password_hash, currency FROM users WHERE `+column+` = ?`, value)
+	if err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Currency); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("user lookup failed", column, value, "err", err)
+		}
+		return nil
+	}
+	return user
+}
+
+func (r *SQL) Exist(username string) *domain.User {
+	return r.findBy("username", username)
+}
+
+func (r *SQL) Find(id string) *domain.User {
+	return r.findBy("user_id", id)
+}
+
+func (r *SQL) SetCurrency(id, currency string) (bool, error) {
+	res, err := r.db.Exec(`UPDATE users SET currency = ? WHERE user_id = ?`, currency, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	refreshPrefix = "refresh:"
+	revokedPrefix = "revoked:"
+)
+
+type RedisTokens struct {
+	ctx   context.Context
+	cache *redis.Client
+}
+
+func NewRedisTokens(cache *redis.Client) *RedisTokens {
+	return &RedisTokens{ctx: context.Background(), cache: cache}
+}
+
+func (r *RedisTokens) SaveRefresh(token *domain.RefreshToken) error {
+	ttl := time.Until(token.ExpiresAt)
+	return r.cache.Set(r.ctx, refreshPrefix+token.Token, token.Subject, ttl).Err()
+}
+
+func (r *RedisTokens) FindRefresh(token string) *domain.RefreshToken {
+	subject, err := r.cache.Get(r.ctx, refreshPrefix+token).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Error("refresh token lookup failed", "err", err)
+		}
+		return nil
+	}
+	return &domain.RefreshToken{Token: token, Subject: subject}
+}
+
+func (r *RedisTokens) TakeRefresh(token string) *domain.RefreshToken {
+	key := refreshPrefix + token
+	subject, err := r.cache.GetDel(r.ctx, key).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Error("refresh token lookup failed", "err", err)
+		}
+		return nil
+	}
+	return &domain.RefreshToken{Token: token, Subject: subject}
+}
+
+func (r *RedisTokens) Revoke(jti string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil // already expired, nothing to revoke
+	}
+	return r.cache.Set(r.ctx, revokedPrefix+jti, 1, ttl).Err()
+}
+
+func (r *RedisTokens) IsRevoked(jti string) (bool, error) {
+	cnt, err := r.cache.Exists(r.ctx, revokedPrefix+jti).Result()
+	if err != nil {
+		return false, err
+	}
+	return cnt > 0, nil
+}
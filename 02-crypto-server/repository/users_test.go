@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"cryptoserver/clean/domain"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestUserRepositories(t *testing.T) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) domain.UserRepository
+	}{
+		{"memory", func(t *testing.T) domain.UserRepository { return NewRai() }},
+		{"sqlite", func(t *testing.T) domain.UserRepository {
+			repo, err := NewSQLite(filepath.Join(t.TempDir(), "users.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { repo.Close() })
+			return repo
+		}},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			repo := backend.open(t)
+			alice := domain.NewUser("id-alice", "alice", "hash")
+			if err := repo.Save(alice); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.Save(domain.NewUser("id-other", "alice", "hash")); !errors.Is(err, domain.ErrUserAlreadyExists) {
+				t.Errorf("saving a taken username: err = %v, want %v", err, domain.ErrUserAlreadyExists)
+			}
+			if found := repo.Find("id-alice"); found == nil || found.Username != "alice" {
+				t.Errorf("Find = %+v, want alice", found)
+			}
+			if found := repo.Exist("alice"); found == nil || found.ID != "id-alice" {
+				t.Errorf("Exist = %+v, want the first alice", found)
+			}
+
+			if ok, err := repo.SetCurrency("id-alice", "eur"); !ok || err != nil {
+				t.Errorf("SetCurrency = %v, %v", ok, err)
+			}
+			if found := repo.Find("id-alice"); found == nil || found.Currency != "eur" {
+				t.Errorf("currency = %+v, want eur", found)
+			}
+			if ok, err := repo.SetCurrency("id-nobody", "eur"); ok || err != nil {
+				t.Errorf("SetCurrency of a missing user = %v, %v, want false", ok, err)
+			}
+		})
+
+		t.Run(backend.name+"/concurrent registrations", func(t *testing.T) {
+			repo := backend.open(t)
+
+			var wg sync.WaitGroup
+			errs := make([]error, 8)
+			for i := range errs {
+				wg.Go(func() { errs[i] = repo.Save(domain.NewUser(fmt.Sprint("id-", i), "bob", "hash")) })
+			}
+			wg.Wait()
+
+			saved := 0
+			for _, err := range errs {
+				if err == nil {
+					saved++
+				} else if !errors.Is(err, domain.ErrUserAlreadyExists) {
+					t.Errorf("err = %v, want %v", err, domain.ErrUserAlreadyExists)
+				}
+			}
+			if saved != 1 {
+				t.Errorf("%d registrations of the same username succeeded, want 1", saved)
+			}
+		})
+	}
+}
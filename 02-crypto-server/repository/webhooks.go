@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	webhookPrefix           = "webhook:"
+	webhookUserPrefix       = "webhook:user:"       // set of webhook ids
+	webhookDeliveriesPrefix = "webhook:deliveries:" // list of attempts, newest first
+	webhookDeadPrefix       = "webhook:dead:"       // list of dead letters, newest first
+
+	maxDeliveries = 200
+	maxDeadLetter = 100
+)
+
+type RedisWebhooks struct {
+	ctx   context.Context
+	cache *redis.Client
+}
+
+func NewRedisWebhooks(cache *redis.Client) *RedisWebhooks {
+	return &RedisWebhooks{ctx: context.Background(), cache: cache}
+}
+
+func (r *RedisWebhooks) SaveWebhook(hook *domain.Webhook) error {
+	hookJSON, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.cache.TxPipeline()
+	pipe.Set(r.ctx, webhookPrefix+hook.ID, hookJSON, 0)
+	pipe.SAdd(r.ctx, webhookUserPrefix+hook.UserID, hook.ID)
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisWebhooks) getWebhook(id string) (*domain.Webhook, error) {
+	hookJSON, err := r.cache.Get(r.ctx, webhookPrefix+id).Result()
+	if err != nil {
+		return nil, err
+	}
+	hook := &domain.Webhook{}
+	if err := json.Unmarshal([]byte(hookJSON), hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (r *RedisWebhooks) DeleteWebhook(userID, id string) (bool, error) {
+	hook, err := r.getWebhook(id)
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if hook.UserID != userID {
+		return false, nil
+	}
+
+	pipe := r.cache.TxPipeline()
+	pipe.Del(r.ctx, webhookPrefix+id)
+	pipe.SRem(r.ctx, webhookUserPrefix+userID, id)
+	_, err = pipe.Exec(r.ctx)
+	return err == nil, err
+}
+
+func (r *RedisWebhooks) Webhooks(userID string) ([]domain.Webhook, error) {
+	ids, err := r.cache.SMembers(r.ctx, webhookUserPrefix+userID).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := make([]domain.Webhook, 0, len(ids))
+	for _, id := range ids {
+		hook, err := r.getWebhook(id)
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				slog.Error("webhook lookup failed", "webhook", id, "err", err)
+			}
+			continue
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, nil
+}
+
+func (r *RedisWebhooks) push(key string, delivery *domain.Delivery, keep int64) error {
+	deliveryJSON, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.cache.TxPipeline()
+	pipe.LPush(r.ctx, key, deliveryJSON)
+	pipe.LTrim(r.ctx, key, 0, keep-1)
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisWebhooks) AddDelivery(delivery *domain.Delivery) error {
+	if delivery.Status == domain.DeliveryDeadLetter {
+		if err := r.push(webhookDeadPrefix+delivery.UserID, delivery, maxDeadLetter); err != nil {
+			return err
+		}
+	}
+
+	logged := *delivery
+	logged.Payload = nil
+	return r.push(webhookDeliveriesPrefix+delivery.UserID, &logged, maxDeliveries)
+}
+
+func (r *RedisWebhooks) list(key string, limit int) ([]domain.Delivery, error) {
+	members, err := r.cache.LRange(r.ctx, key, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]domain.Delivery, 0, len(members))
+	for _, member := range members {
+		delivery := domain.Delivery{}
+		if err := json.Unmarshal([]byte(member), &delivery); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (r *RedisWebhooks) Deliveries(userID string, limit int) ([]domain.Delivery, error) {
+	return r.list(webhookDeliveriesPrefix+userID, limit)
+}
+
+func (r *RedisWebhooks) DeadLetters(userID string, limit int) ([]domain.Delivery, error) {
+	return r.list(webhookDeadPrefix+userID, limit)
+}
@@ -0,0 +1,204 @@
+package rest
+
+import (
+	"bytes"
+	"cryptoserver/clean/composure"
+	"cryptoserver/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+type tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newAuthServer serves the /auth routes over in-memory users and a miniredis
+// token store.
+func newAuthServer(t *testing.T) http.Handler {
+	t.Helper()
+	cache := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { cache.Close() })
+
+	cfg := config.Default()
+	cfg.Storage.Backend = "memory"
+	cfg.Auth.JWTSecret = "0123456789abcdef0123456789abcdef"
+	auth, _, err := composure.NewAuth(cfg, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	authRoute(r, auth)
+	return r
+}
+
+// call sends body as JSON with token as the bearer, decodes the response
+// into out when it is not nil and returns the status and the problem code.
+func call(t *testing.T, h http.Handler, method, path, token string, body, out any) (int, string) {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code >= 400 {
+		var problem struct {
+			Code string `json:"code"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &problem)
+		return rec.Code, problem.Code
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code, ""
+}
+
+func register(t *testing.T, h http.Handler, username string) tokens {
+	t.Helper()
+	var issued tokens
+	status, code := call(t, h, http.MethodPost, "/auth/register", "",
+		map[string]string{"username": username, "password": "hunter22"}, &issued)
+	if status != http.StatusCreated {
+		t.Fatalf("register %s: %d %s", username, status, code)
+	}
+	return issued
+}
+
+func TestRefreshRotation(t *testing.T) {
+	h := newAuthServer(t)
+	issued := register(t, h, "alice")
+
+	var rotated tokens
+	if status, code := call(t, h, http.MethodPost, "/auth/refresh", "", map[string]string{"refresh_token": issued.RefreshToken}, &rotated); status != http.StatusOK {
+		t.Fatalf("refresh: %d %s", status, code)
+	}
+	if rotated.RefreshToken == issued.RefreshToken || rotated.Token == "" {
+		t.Fatalf("refresh did not rotate: %+v", rotated)
+	}
+
+	status, code := call(t, h, http.MethodPost, "/auth/refresh", "", map[string]string{"refresh_token": issued.RefreshToken}, nil)
+	if status != http.StatusUnauthorized || code != "invalid_refresh_token" {
+		t.Errorf("reusing the old refresh token: %d %s, want 401 invalid_refresh_token", status, code)
+	}
+
+	if status, code := call(t, h, http.MethodGet, "/auth/me", rotated.Token, nil, nil); status != http.StatusOK {
+		t.Errorf("rotated access token: %d %s", status, code)
+	}
+	if status, code := call(t, h, http.MethodPost, "/auth/refresh", "", map[string]string{"refresh_token": rotated.RefreshToken}, nil); status != http.StatusOK {
+		t.Errorf("rotated refresh token: %d %s", status, code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name       string
+		refresh    func(own, other tokens) map[string]string
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "own refresh token",
+			refresh:    func(own, other tokens) map[string]string { return map[string]string{"refresh_token": own.RefreshToken} },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "another user's refresh token",
+			refresh: func(own, other tokens) map[string]string {
+				return map[string]string{"refresh_token": other.RefreshToken}
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "invalid_refresh_token",
+		},
+		{
+			name:       "bogus refresh token",
+			refresh:    func(own, other tokens) map[string]string { return map[string]string{"refresh_token": "bogus"} },
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "invalid_refresh_token",
+		},
+		{
+			name:       "missing refresh token",
+			refresh:    func(own, other tokens) map[string]string { return nil },
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "missing_refresh_token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newAuthServer(t)
+			own := register(t, h, "alice")
+			other := register(t, h, "bob")
+
+			status, code := call(t, h, http.MethodPost, "/auth/logout", own.Token, tt.refresh(own, other), nil)
+			if status != tt.wantStatus || code != tt.wantCode {
+				t.Fatalf("logout: %d %s, want %d %s", status, code, tt.wantStatus, tt.wantCode)
+			}
+
+			loggedOut := tt.wantStatus == http.StatusOK
+			wantMe, wantRefresh := http.StatusOK, http.StatusOK
+			if loggedOut {
+				wantMe, wantRefresh = http.StatusUnauthorized, http.StatusUnauthorized
+			}
+			if status, code := call(t, h, http.MethodGet, "/auth/me", own.Token, nil, nil); status != wantMe {
+				t.Errorf("access token after logout: %d %s, want %d", status, code, wantMe)
+			} else if loggedOut && code != "revoked_token" {
+				t.Errorf("access token after logout: code %s, want revoked_token", code)
+			}
+			if status, code := call(t, h, http.MethodPost, "/auth/refresh", "", map[string]string{"refresh_token": own.RefreshToken}, nil); status != wantRefresh {
+				t.Errorf("own refresh token after logout: %d %s, want %d", status, code, wantRefresh)
+			}
+			if status, code := call(t, h, http.MethodPost, "/auth/refresh", "", map[string]string{"refresh_token": other.RefreshToken}, nil); status != http.StatusOK {
+				t.Errorf("another user's session was ended: %d %s", status, code)
+			}
+		})
+	}
+}
+
+func TestAccountIsTheCaller(t *testing.T) {
+	h := newAuthServer(t)
+	alice := register(t, h, "alice")
+	bob := register(t, h, "bob")
+
+	type account struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"`
+		Currency string `json:"currency"`
+	}
+	var changed account
+	if status, code := call(t, h, http.MethodPatch, "/auth/me", alice.Token, map[string]string{"currency": "EUR"}, &changed); status != http.StatusOK {
+		t.Fatalf("update account: %d %s", status, code)
+	}
+	if changed.Username != "alice" || changed.Currency != "eur" {
+		t.Errorf("updated account = %+v, want alice in eur", changed)
+	}
+
+	var other account
+	if status, code := call(t, h, http.MethodGet, "/auth/me", bob.Token, nil, &other); status != http.StatusOK {
+		t.Fatalf("get account: %d %s", status, code)
+	}
+	if other.Username != "bob" || other.Currency != "usd" || other.UserID == changed.UserID {
+		t.Errorf("bob's account = %+v, want bob in usd with a separate id", other)
+	}
+
+	for _, token := range []string{"", "not-a-jwt", alice.Token + "x"} {
+		if status, _ := call(t, h, http.MethodGet, "/auth/me", token, nil, nil); status != http.StatusUnauthorized {
+			t.Errorf("token %q: %d, want 401", token, status)
+		}
+	}
+}
@@ -1,62 +1,101 @@
 package rest
 
 import (
+	"context"
+	"cryptoserver/apperror"
 	"cryptoserver/clean/composure"
+	"cryptoserver/clean/controller"
+	"cryptoserver/config"
 	"cryptoserver/crypto"
+	"cryptoserver/errorfmt"
+	"cryptoserver/health"
+	"cryptoserver/logging"
+	"cryptoserver/metrics"
+	"cryptoserver/provider"
+	"cryptoserver/repository"
+	"cryptoserver/security"
+	"cryptoserver/webhook"
 	"fmt"
+	"log/slog"
 	"net/http"
-
-	//"context"
-	"strings"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
 )
 
-func authRoute(r chi.Router) {
-	auth := composure.NewAuth()
+var ErrRouteNotFound = apperror.New(apperror.NotFound, "route_not_found", "Route not found.")
+
+func authRoute(r chi.Router, auth *controller.Auth) {
 	r.Route("/auth", func(r chi.Router) {
 		r.Post("/register", auth.RegisterUser) // POST /auth/register
 		r.Post("/login", auth.LoginUser)       // POST /auth/login
+		r.Post("/refresh", auth.RefreshToken)  // POST /auth/refresh
+
+		r.Group(func(r chi.Router) {
+			r.Use(authMiddleware(auth))
+			r.Post("/logout", auth.LogoutUser) // POST /auth/logout
+			r.Get("/me", auth.GetAccount)      // GET /auth/me
+			r.Patch("/me", auth.UpdateAccount) // PATCH /auth/me
+		})
 	})
 }
 
-func authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "You are not authorized.", http.StatusUnauthorized)
-			return
-		}
+func authMiddleware(auth *controller.Auth) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString := controller.BearerToken(r)
+			if tokenString == "" {
+				metrics.ObserveAuth("token", false)
+				errorfmt.Write(w, r, controller.ErrNoToken)
+				return
+			}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		secret := []byte("super_secret_key_that_should_be_long_and_random") // fix this, need security
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
-			if token.Method.Alg() != "HS256" {
-				return nil, fmt.Errorf("algo %v, expected HS256\n", token.Header["alg"])
+			principal, err := auth.ParseToken(r.Context(), tokenString)
+			metrics.ObserveAuth("token", err == nil)
+			if err != nil {
+				errorfmt.Write(w, r, err)
+				return
 			}
-			return secret, nil
+
+			logging.SetUser(r.Context(), principal.UserID)
+			ctx := security.WithPrincipal(r.Context(), principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
+	}
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token.", http.StatusUnauthorized)
-			return
+// queryToken accepts the access token as ?access_token= for WebSocket
+// handshakes and EventSource streams, browsers cannot set the Authorization
+// header on those.
+func queryToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
 		}
-
-		//const tokenKey = "token"
-		//ctx := context.WithValue(context.Background(), tokenKey, token)
-		next.ServeHTTP(w, r /*.WithContext(ctx)*/)
+		next.ServeHTTP(w, r)
 	})
 }
 
-func cryptoRoute(r chi.Router) {
+func healthRoute(r chi.Router, checker *health.Checker) {
+	r.Get("/healthz", checker.Healthz) // GET /healthz
+	r.Get("/readyz", checker.Readyz)   // GET /readyz
+}
+
+func cryptoRoute(r chi.Router, auth *controller.Auth, api *crypto.API) {
+	r.Get("/collector", api.CollectorStatus)                                          // GET /collector
+	r.With(queryToken, authMiddleware(auth)).Get("/ws", api.Ticks)                    // GET /ws
+	r.With(queryToken, authMiddleware(auth)).Get("/crypto/stream", api.StreamCryptos) // GET /crypto/stream
+
 	r.Route("/crypto", func(r chi.Router) {
-		r.Use(authMiddleware)
-		api := crypto.NewAPI()
-		r.Get("/", api.ListCryptos)  // GET  /crypto
-		r.Post("/", api.WatchCrypto) // POST /crypto
+		r.Use(authMiddleware(auth))
+		r.Get("/", api.ListCryptos)         // GET  /crypto
+		r.Post("/", api.WatchCrypto)        // POST /crypto
+		r.Get("/search", api.SearchCryptos) // GET  /crypto/search
+		r.Get("/quotes", api.GetQuotes)     // GET  /crypto/quotes
+		r.Post("/quotes", api.PostQuotes)   // POST /crypto/quotes
 
 		r.Route("/{symbol}", func(r chi.Router) {
 			r.Get("/", api.GetCrypto)            // GET    /crypto/{symbol}
@@ -68,23 +107,135 @@ func cryptoRoute(r chi.Router) {
 	})
 }
 
-func CreateAndRun() error {
+func alertRoute(r chi.Router, auth *controller.Auth, api *crypto.API) {
+	r.Route("/alerts", func(r chi.Router) {
+		r.Use(authMiddleware(auth))
+		r.Get("/", api.ListAlerts)            // GET    /alerts
+		r.Post("/", api.CreateAlert)          // POST   /alerts
+		r.Get("/events", api.ListAlertEvents) // GET    /alerts/events
+		r.Delete("/{id}", api.DeleteAlert)    // DELETE /alerts/{id}
+	})
+}
+
+func portfolioRoute(r chi.Router, auth *controller.Auth, api *crypto.API) {
+	r.Route("/portfolio", func(r chi.Router) {
+		r.Use(authMiddleware(auth))
+		r.Get("/", api.GetPortfolio)                          // GET    /portfolio
+		r.Get("/transactions", api.ListTransactions)          // GET    /portfolio/transactions
+		r.Post("/transactions", api.AddTransaction)           // POST   /portfolio/transactions
+		r.Delete("/transactions/{id}", api.DeleteTransaction) // DELETE /portfolio/transactions/{id}
+	})
+}
+
+func webhookRoute(r chi.Router, auth *controller.Auth, dispatcher *webhook.Dispatcher) {
+	r.Route("/webhooks", func(r chi.Router) {
+		r.Use(authMiddleware(auth))
+		r.Get("/", dispatcher.ListWebhooks)                // GET    /webhooks
+		r.Post("/", dispatcher.CreateWebhook)              // POST   /webhooks
+		r.Get("/deliveries", dispatcher.ListDeliveries)    // GET    /webhooks/deliveries
+		r.Get("/dead-letters", dispatcher.ListDeadLetters) // GET    /webhooks/dead-letters
+		r.Delete("/{id}", dispatcher.DeleteWebhook)        // DELETE /webhooks/{id}
+	})
+}
+
+// closeLogged runs a shutdown step, logging instead of failing so the rest
+// of the teardown still happens.
+func closeLogged(name string, close func() error) {
+	if err := close(); err != nil {
+		slog.Error("shutdown step failed", "step", name, "err", err)
+	}
+}
+
+// CreateAndRun serves until ctx is cancelled, then drains in-flight requests
+// and tears dependencies down in reverse order of creation: background
+// workers, user storage and finally the redis client.
+func CreateAndRun(ctx context.Context, cfg *config.Config) error {
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	r.Use(logging.Middleware)
+	r.Use(metrics.Middleware)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.URLFormat)
-	r.Use(middleware.RequestID)
 	r.Use(render.SetContentType(render.ContentTypeJSON))
 
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		errorfmt.Write(w, r, ErrRouteNotFound)
+	})
+
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "Root of cryptoserver.")
 	})
 
+	r.Method(http.MethodGet, "/metrics", metrics.Handler()) // GET /metrics
+
 	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
 		panic("test")
 	})
 
-	authRoute(r)
-	cryptoRoute(r)
-	return http.ListenAndServe(":8080", r)
+	cache := redis.NewClient(&redis.Options{
+		Addr: cfg.Redis.Addr,
+	})
+	defer closeLogged("redis", cache.Close)
+
+	auth, closeAuth, err := composure.NewAuth(cfg, cache)
+	if err != nil {
+		return err
+	}
+	defer closeLogged("user storage", closeAuth)
+
+	series := repository.NewRedisSeries(cache, cfg.Series.Retention)
+	upstream := provider.NewThrottled(provider.NewCoinGecko(cfg.CoinGecko), cfg.CoinGecko)
+	dispatcher := webhook.NewDispatcher(repository.NewRedisWebhooks(cache), nil, cfg.Webhooks)
+	alerts := webhook.Notify(repository.NewRedisAlerts(cache), dispatcher)
+	portfolio := repository.NewRedisPortfolio(cache)
+	api := crypto.NewAPI(upstream, series, alerts, portfolio, cache, cfg)
+
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		stopWorkers()
+		wg.Wait()
+	}()
+	wg.Go(func() { api.BackgroundCaching(workers) })
+	wg.Go(func() { api.RefreshSymbols(workers) })
+	wg.Go(func() { api.ListenInvalidations(workers) })
+	wg.Go(func() { api.SweepLocalCaches(workers) })
+	wg.Go(func() { dispatcher.Run(workers) })
+
+	healthRoute(r, health.NewChecker(cache, upstream, api))
+	authRoute(r, auth)
+	cryptoRoute(r, auth, api)
+	alertRoute(r, auth, api)
+	portfolioRoute(r, auth, api)
+	webhookRoute(r, auth, dispatcher)
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	srv.RegisterOnShutdown(api.StopStreams)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "drain", cfg.Server.ShutdownTimeout)
+	drain, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(drain); err != nil {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,29 @@
+package security
+
+import (
+	"context"
+	"cryptoserver/apperror"
+	"time"
+)
+
+var ErrNoPrincipal = apperror.New(apperror.Unauthorized, "unauthorized", "You are not authorized.")
+
+type Principal struct {
+	UserID    string
+	TokenID   string
+	ExpiresAt time.Time
+	Currency  string // the user's default quote currency
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// CurrentUser returns the principal that authMiddleware attached to the
+// request context.
+func CurrentUser(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,319 @@
+package swr
+
+import (
+	"context"
+	"cryptoserver/lru"
+	"cryptoserver/metrics"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// Status tells how a value was served, it is sent as X-Cache.
+type Status string
+
+const (
+	Hit   Status = "HIT"   // younger than the soft TTL
+	Stale Status = "STALE" // older than the soft TTL, being refreshed
+	Miss  Status = "MISS"  // fetched for this request
+)
+
+const (
+	valueField    = "value"
+	storedAtField = "stored_at" // unix milliseconds
+)
+
+type Result struct {
+	Value  []byte
+	Status Status
+	Age    time.Duration
+}
+
+// WriteHeaders reports the freshness of res as Age and X-Cache.
+func (res Result) WriteHeaders(w http.ResponseWriter) {
+	w.Header().Set("Age", strconv.Itoa(int(res.Age.Seconds())))
+	w.Header().Set("X-Cache", string(res.Status))
+}
+
+// Fetch loads the current value of a key from upstream.
+type Fetch func(ctx context.Context) ([]byte, error)
+
+// Cache is a stale-while-revalidate cache on top of Redis. An entry is fresh
+// for the soft TTL, after that it is still served while it is refreshed in
+// the background, until the hard TTL drops it. A failed refresh keeps the
+// stale entry, so upstream outages and rate limits only surface once there
+// is nothing left to serve. Entries are hashes of the value and the time it
+// was stored. name labels lookups in metrics.
+//
+// Lookups go through an in-process LRU first, holding up to localBytes of
+// entries for localTTL. Invalidate evicts a key from the LRU of every
+// instance running Listen.
+type Cache struct {
+	client *redis.Client
+	name   string
+	soft   time.Duration
+	hard   time.Duration
+	local  *lru.Cache[entry]
+	group  singleflight.Group
+
+	mu         sync.Mutex
+	refreshing map[string]bool // keys with a background refresh in flight
+}
+
+type entry struct {
+	value    []byte
+	storedAt time.Time
+}
+
+func New(client *redis.Client, name string, soft, hard time.Duration, localBytes int, localTTL time.Duration) *Cache {
+	return &Cache{
+		client: client,
+		name:   name,
+		soft:   soft,
+		hard:   hard,
+		local:  lru.New(localBytes, localTTL, weigh),
+
+		refreshing: make(map[string]bool),
+	}
+}
+
+func weigh(key string, e entry) int {
+	return len(key) + len(e.value)
+}
+
+// Sweep drops expired entries from the LRU.
+func (c *Cache) Sweep() {
+	c.local.Sweep()
+}
+
+func (c *Cache) channel() string {
+	return "invalidate:" + c.name
+}
+
+func (c *Cache) result(e entry, now time.Time) Result {
+	age := max(now.Sub(e.storedAt), 0)
+	status := Hit
+	if age >= c.soft {
+		status = Stale
+	}
+	return Result{Value: e.value, Status: status, Age: age}
+}
+
+// Lookup returns the entry under key, ok is false if there is none. The
+// status is Hit or Stale by age alone, Lookup never refreshes.
+func (c *Cache) Lookup(ctx context.Context, key string) (Result, bool, error) {
+	results, err := c.LookupMany(ctx, key)
+	if err != nil {
+		return Result{}, false, err
+	}
+	res := results[0]
+	return res, res.Value != nil, nil
+}
+
+// LookupMany is Lookup for several keys in one round trip, or none if the
+// LRU has them all. Keys without an entry get a zero Result.
+func (c *Cache) LookupMany(ctx context.Context, keys ...string) ([]Result, error) {
+	now := time.Now()
+	results := make([]Result, len(keys))
+	remote := make([]int, 0, len(keys))
+	for i, key := range keys {
+		if e, ok := c.local.Get(key); ok {
+			results[i] = c.result(e, now)
+		} else {
+			remote = append(remote, i)
+		}
+	}
+	if len(remote) == 0 {
+		return results, nil
+	}
+
+	pipe := c.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(remote))
+	for j, i := range remote {
+		cmds[j] = pipe.HMGet(ctx, keys[i], valueField, storedAtField)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		// entries written before this cache existed are plain strings and
+		// fail with WRONGTYPE, they are treated as missing
+		var redisErr redis.Error
+		if !errors.As(err, &redisErr) {
+			return nil, err
+		}
+	}
+
+	for j, i := range remote {
+		fields, err := cmds[j].Result()
+		if err != nil || len(fields) != 2 {
+			continue
+		}
+		value, ok := fields[0].(string)
+		storedAtString, _ := fields[1].(string)
+		storedAt, err := strconv.ParseInt(storedAtString, 10, 64)
+		if !ok || err != nil {
+			continue
+		}
+
+		e := entry{value: []byte(value), storedAt: time.UnixMilli(storedAt)}
+		c.local.Set(keys[i], e)
+		results[i] = c.result(e, now)
+	}
+	return results, nil
+}
+
+// Store saves value under key as fresh.
+func (c *Cache) Store(ctx context.Context, key string, value []byte) error {
+	e := entry{value: value, storedAt: time.Now()}
+	pipe := c.client.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, valueField, value, storedAtField, e.storedAt.UnixMilli())
+	pipe.PExpire(ctx, key, c.hard)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	c.local.Set(key, e)
+	return nil
+}
+
+// Invalidate evicts keys from the LRU of every instance, the next lookup
+// reads them from Redis.
+func (c *Cache) Invalidate(ctx context.Context, keys ...string) error {
+	pipe := c.client.Pipeline()
+	for _, key := range keys {
+		c.local.Delete(key)
+		pipe.Publish(ctx, c.channel(), key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Listen applies invalidations published by any instance until ctx is
+// cancelled.
+func (c *Cache) Listen(ctx context.Context) {
+	sub := c.client.Subscribe(ctx, c.channel())
+	defer sub.Close()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-messages:
+			c.local.Delete(msg.Payload)
+		}
+	}
+}
+
+// Get serves key from the cache, refreshing it in the background when it is
+// stale, and calls fetch only if there is no entry at all. Concurrent
+// fetches of one key are coalesced.
+func (c *Cache) Get(ctx context.Context, key string, fetch Fetch) (Result, error) {
+	res, ok, err := c.Lookup(ctx, key)
+	if err != nil {
+		slog.WarnContext(ctx, "cache lookup failed", "key", key, "err", err)
+	}
+
+	switch {
+	case ok && res.Status == Hit:
+		metrics.ObserveCacheResult(c.name, "hit")
+		return res, nil
+	case ok:
+		metrics.ObserveCacheResult(c.name, "stale")
+		c.Revalidate(ctx, key, fetch)
+		return res, nil
+	}
+
+	metrics.ObserveCacheResult(c.name, "miss")
+	value, err := c.fetch(ctx, key, fetch)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Value: value, Status: Miss}, nil
+}
+
+// Revalidate refreshes key in the background. On failure the current entry
+// is kept and served until its hard TTL.
+func (c *Cache) Revalidate(ctx context.Context, key string, fetch Fetch) {
+	if len(c.claim(key)) == 0 {
+		return
+	}
+	done := c.group.DoChan(key, c.load(ctx, key, fetch))
+	go func() {
+		res := <-done
+		c.release(key)
+		if res.Err != nil {
+			slog.WarnContext(ctx, "cache refresh failed, serving stale", "key", key, "err", res.Err)
+		}
+	}()
+}
+
+// FetchMany loads several keys at once and stores them.
+type FetchMany func(ctx context.Context, keys []string) error
+
+// RevalidateMany is Revalidate for keys loaded together, with one call to
+// fetch. Keys already being refreshed are left out.
+func (c *Cache) RevalidateMany(ctx context.Context, keys []string, fetch FetchMany) {
+	claimed := c.claim(keys...)
+	if len(claimed) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer c.release(claimed...)
+		if err := fetch(ctx, claimed); err != nil {
+			slog.WarnContext(ctx, "cache refresh failed, serving stale", "keys", len(claimed), "err", err)
+		}
+	}()
+}
+
+// claim marks keys as being refreshed and returns those that were not yet.
+func (c *Cache) claim(keys ...string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	claimed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !c.refreshing[key] {
+			c.refreshing[key] = true
+			claimed = append(claimed, key)
+		}
+	}
+	return claimed
+}
+
+func (c *Cache) release(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.refreshing, key)
+	}
+}
+
+func (c *Cache) fetch(ctx context.Context, key string, fetch Fetch) ([]byte, error) {
+	v, err, _ := c.group.Do(key, c.load(ctx, key, fetch))
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// load fetches and stores key. It is detached from the caller's
+// cancellation, other callers may be waiting for the same result.
+func (c *Cache) load(ctx context.Context, key string, fetch Fetch) func() (any, error) {
+	ctx = context.WithoutCancel(ctx)
+	return func() (any, error) {
+		value, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Store(ctx, key, value); err != nil {
+			slog.ErrorContext(ctx, "caching fetched value failed", "key", key, "err", err)
+		}
+		return value, nil
+	}
+}
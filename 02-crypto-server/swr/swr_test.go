@@ -0,0 +1,182 @@
+package swr
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	soft = time.Minute
+	hard = time.Hour
+)
+
+func newTestCache(t *testing.T) (*Cache, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return New(client, "test", soft, hard, 1<<20, time.Minute), mr
+}
+
+// storeAged writes an entry stored age ago straight to Redis, bypassing the LRU.
+func storeAged(mr *miniredis.Miniredis, key, value string, age time.Duration) {
+	mr.HSet(key, valueField, value, storedAtField, strconv.FormatInt(time.Now().Add(-age).UnixMilli(), 10))
+}
+
+// fetcher counts its calls and returns value, or err when it is set. Calls
+// block until release is closed when it is not nil.
+type fetcher struct {
+	value   string
+	err     error
+	release chan struct{}
+	calls   atomic.Int32
+	done    chan struct{}
+}
+
+func newFetcher(value string) *fetcher {
+	return &fetcher{value: value, done: make(chan struct{}, 10)}
+}
+
+func (f *fetcher) fetch(ctx context.Context) ([]byte, error) {
+	defer func() { f.done <- struct{}{} }()
+	f.calls.Add(1)
+	if f.release != nil {
+		<-f.release
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []byte(f.value), nil
+}
+
+// wait blocks until a call to fetch has returned.
+func (f *fetcher) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-f.done:
+	case <-time.After(time.Second):
+		t.Fatal("fetch was not called")
+	}
+}
+
+func TestGet(t *testing.T) {
+	errUpstream := errors.New("upstream down")
+
+	tests := []struct {
+		name       string
+		age        time.Duration // of the stored entry, 0 for none
+		fetchErr   error
+		wantValue  string
+		wantStatus Status
+		wantErr    error
+		wantCalls  int32
+		wantStored string // after a background refresh
+	}{
+		{name: "miss fetches", wantValue: "new", wantStatus: Miss, wantCalls: 1, wantStored: "new"},
+		{name: "miss fails", fetchErr: errUpstream, wantErr: errUpstream, wantCalls: 1},
+		{name: "fresh hit", age: time.Second, wantValue: "old", wantStatus: Hit, wantStored: "old"},
+		{name: "stale is served and refreshed", age: 2 * soft, wantValue: "old", wantStatus: Stale, wantCalls: 1, wantStored: "new"},
+		{name: "stale outlives a failed refresh", age: 2 * soft, fetchErr: errUpstream, wantValue: "old", wantStatus: Stale, wantCalls: 1, wantStored: "old"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mr := newTestCache(t)
+			if tt.age > 0 {
+				storeAged(mr, "k", "old", tt.age)
+			}
+			f := newFetcher("new")
+			f.err = tt.fetchErr
+
+			res, err := c.Get(context.Background(), "k", f.fetch)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if string(res.Value) != tt.wantValue || res.Status != tt.wantStatus {
+				t.Errorf("Get = %q %s, want %q %s", res.Value, res.Status, tt.wantValue, tt.wantStatus)
+			}
+			if tt.wantStatus == Stale && res.Age < tt.age-time.Second {
+				t.Errorf("age = %v, want about %v", res.Age, tt.age)
+			}
+			if tt.wantCalls > 0 {
+				f.wait(t)
+			}
+			if calls := f.calls.Load(); calls != tt.wantCalls {
+				t.Errorf("fetched %d times, want %d", calls, tt.wantCalls)
+			}
+
+			if tt.wantStored == "" {
+				return
+			}
+			// the refresh stores before it returns, give it a moment to land
+			deadline := time.Now().Add(time.Second)
+			for {
+				stored := mr.HGet("k", valueField)
+				if stored == tt.wantStored {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("stored %q, want %q", stored, tt.wantStored)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestStaleRefreshesOnce(t *testing.T) {
+	c, mr := newTestCache(t)
+	storeAged(mr, "k", "old", 2*soft)
+	f := newFetcher("new")
+	f.release = make(chan struct{})
+
+	for range 3 {
+		if res, err := c.Get(context.Background(), "k", f.fetch); err != nil || res.Status != Stale {
+			t.Fatalf("Get = %s, %v, want stale", res.Status, err)
+		}
+	}
+	close(f.release)
+	f.wait(t)
+	if calls := f.calls.Load(); calls != 1 {
+		t.Errorf("stale reads started %d refreshes, want 1", calls)
+	}
+}
+
+func TestStore(t *testing.T) {
+	c, mr := newTestCache(t)
+	ctx := context.Background()
+
+	if err := c.Store(ctx, "k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("k"); ttl != hard {
+		t.Errorf("ttl = %v, want the hard TTL %v", ttl, hard)
+	}
+	// a second instance has no LRU entry and reads it back from Redis
+	other := New(c.client, "test", soft, hard, 1<<20, time.Minute)
+	if res, ok, err := other.Lookup(ctx, "k"); !ok || err != nil || string(res.Value) != "v" || res.Status != Hit {
+		t.Errorf("Lookup = %q %s %v %v, want a hit", res.Value, res.Status, ok, err)
+	}
+
+	// entries written before the cache existed are plain strings
+	mr.Set("legacy", "v")
+	if _, ok, err := c.Lookup(ctx, "legacy"); ok || err != nil {
+		t.Errorf("legacy Lookup = %v, %v, want a miss", ok, err)
+	}
+}
+
+func TestWriteHeaders(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Result{Status: Stale, Age: 90 * time.Second}.WriteHeaders(rec)
+	if age, cache := rec.Header().Get("Age"), rec.Header().Get("X-Cache"); age != "90" || cache != "STALE" {
+		t.Errorf("Age %q, X-Cache %q, want 90, STALE", age, cache)
+	}
+}
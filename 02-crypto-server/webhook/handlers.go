@@ -0,0 +1,240 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"cryptoserver/apperror"
+	"cryptoserver/clean/domain"
+	"cryptoserver/errorfmt"
+	"cryptoserver/security"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 100
+)
+
+var (
+	ErrInvalidJSON     = apperror.New(apperror.Validation, "invalid_json", "Invalid json.")
+	ErrInvalidURL      = apperror.New(apperror.Validation, "invalid_url", "URL must be an absolute http(s) URL.")
+	ErrPrivateURL      = apperror.New(apperror.Validation, "private_url", "URL must point to a public address.")
+	ErrInvalidLimit    = apperror.New(apperror.Validation, "invalid_limit", "Limit must be between 1 and 100.")
+	ErrWebhookNotFound = apperror.New(apperror.NotFound, "webhook_not_found", "Webhook doesn't exist.")
+)
+
+type WebhookResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"` // only on registration
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DeliveryResponse struct {
+	ID         string          `json:"id"`
+	WebhookID  string          `json:"webhook_id"`
+	EventID    string          `json:"event_id"`
+	URL        string          `json:"url"`
+	Attempt    int             `json:"attempt"`
+	Status     string          `json:"status"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	At         time.Time       `json:"at"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+func newWebhookResponse(hook *domain.Webhook) WebhookResponse {
+	return WebhookResponse{ID: hook.ID, URL: hook.URL, CreatedAt: hook.CreatedAt}
+}
+
+func newDeliveryResponse(delivery *domain.Delivery) DeliveryResponse {
+	return DeliveryResponse{
+		ID:         delivery.ID,
+		WebhookID:  delivery.WebhookID,
+		EventID:    delivery.EventID,
+		URL:        delivery.URL,
+		Attempt:    delivery.Attempt,
+		Status:     string(delivery.Status),
+		StatusCode: delivery.StatusCode,
+		Error:      delivery.Error,
+		At:         delivery.At,
+		Payload:    delivery.Payload,
+	}
+}
+
+func currentUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	principal, ok := security.CurrentUser(r.Context())
+	if !ok {
+		errorfmt.Write(w, r, security.ErrNoPrincipal)
+		return "", false
+	}
+	return principal.UserID, true
+}
+
+func parseLimit(r *http.Request) (int, error) {
+	limitString := r.URL.Query().Get("limit")
+	if limitString == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(limitString)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, ErrInvalidLimit
+	}
+	return limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v any, status int) {
+	clientJSON, err := json.Marshal(v)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(clientJSON)
+}
+
+func (d *Dispatcher) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	hooks, err := d.store.Webhooks(userID)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	webhooks := make([]WebhookResponse, len(hooks))
+	for i := range hooks {
+		webhooks[i] = newWebhookResponse(&hooks[i])
+	}
+	writeJSON(w, r, map[string][]WebhookResponse{"webhooks": webhooks}, http.StatusOK)
+}
+
+func (d *Dispatcher) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		errorfmt.Write(w, r, ErrInvalidJSON)
+		return
+	}
+
+	u, err := url.Parse(body.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errorfmt.Write(w, r, ErrInvalidURL)
+		return
+	}
+	// hosts are checked again on every delivery, this only catches the
+	// obvious cases early
+	if !d.cfg.AllowPrivate {
+		host := u.Hostname()
+		addr, err := netip.ParseAddr(host)
+		if (err == nil && !isPublic(addr)) || strings.EqualFold(host, "localhost") {
+			errorfmt.Write(w, r, ErrPrivateURL)
+			return
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	hook := &domain.Webhook{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		URL:       u.String(),
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := d.store.SaveWebhook(hook); err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	response := newWebhookResponse(hook)
+	response.Secret = hook.Secret
+	writeJSON(w, r, response, http.StatusCreated)
+}
+
+func (d *Dispatcher) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := d.store.DeleteWebhook(userID, chi.URLParam(r, "id"))
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	} else if !deleted {
+		errorfmt.Write(w, r, ErrWebhookNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+func (d *Dispatcher) listDeliveries(w http.ResponseWriter, r *http.Request,
+	list func(userID string, limit int) ([]domain.Delivery, error)) {
+	defer r.Body.Close()
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	records, err := list(userID, limit)
+	if err != nil {
+		errorfmt.Write(w, r, err)
+		return
+	}
+
+	deliveries := make([]DeliveryResponse, len(records))
+	for i := range records {
+		deliveries[i] = newDeliveryResponse(&records[i])
+	}
+	writeJSON(w, r, map[string][]DeliveryResponse{"deliveries": deliveries}, http.StatusOK)
+}
+
+// ListDeliveries is the delivery log, one entry per attempt.
+func (d *Dispatcher) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	d.listDeliveries(w, r, d.store.Deliveries)
+}
+
+// ListDeadLetters returns deliveries that were given up on, with the payload
+// that could not be delivered.
+func (d *Dispatcher) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	d.listDeliveries(w, r, d.store.DeadLetters)
+}
@@ -0,0 +1,316 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"cryptoserver/clean/domain"
+	"cryptoserver/config"
+	"cryptoserver/metrics"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SignatureHeader = "X-Cryptoserver-Signature"
+	TimestampHeader = "X-Cryptoserver-Timestamp"
+	EventHeader     = "X-Cryptoserver-Event"
+	DeliveryHeader  = "X-Cryptoserver-Delivery"
+
+	alertFired = "alert.fired"
+)
+
+var (
+	errQueueFull  = errors.New("delivery queue is full")
+	errShutdown   = errors.New("server shut down before delivery")
+	errNotPublic  = errors.New("webhook address is not public")
+	sharedAddress = netip.MustParsePrefix("100.64.0.0/10") // carrier-grade NAT
+)
+
+// isPublic reports whether addr is routable on the internet, webhooks must
+// not reach into the network the server runs in.
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddress.Contains(addr)
+}
+
+// publicOnly is a net.Dialer Control refusing non-public addresses. It runs
+// after name resolution, so a host re-resolving to an internal address is
+// refused as well.
+func publicOnly(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublic(addrPort.Addr()) {
+		return errNotPublic
+	}
+	return nil
+}
+
+// Sign returns the value of SignatureHeader: "sha256=" followed by the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook secret.
+// Receivers should recompute it and reject stale timestamps.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type EventData struct {
+	RuleID    string    `json:"rule_id"`
+	Symbol    string    `json:"symbol"`
+	Condition string    `json:"condition"`
+	Threshold float64   `json:"threshold"`
+	Price     float64   `json:"price"`
+	Change    float64   `json:"change,omitempty"`
+	FiredAt   time.Time `json:"fired_at"`
+}
+
+type Payload struct {
+	ID   string    `json:"id"` // alert event id, receivers can dedupe retries on it
+	Type string    `json:"type"`
+	Data EventData `json:"data"`
+}
+
+func newPayload(event *domain.AlertEvent) Payload {
+	return Payload{
+		ID:   event.ID,
+		Type: alertFired,
+		Data: EventData{
+			RuleID:    event.RuleID,
+			Symbol:    event.Symbol,
+			Condition: string(event.Condition),
+			Threshold: event.Threshold,
+			Price:     event.Price,
+			Change:    event.Change,
+			FiredAt:   event.FiredAt,
+		},
+	}
+}
+
+type job struct {
+	hook     domain.Webhook
+	delivery domain.Delivery
+	payload  []byte
+}
+
+// Dispatcher POSTs alert events to the webhooks of their owner. Failed
+// attempts are retried with exponential backoff and dead-lettered after
+// webhooks.max_attempts. At most webhooks.queue_size deliveries are pending
+// at a time, events beyond that are dead-lettered right away.
+type Dispatcher struct {
+	store  domain.WebhookStore
+	client *http.Client
+	cfg    config.Webhooks
+	queue  chan job
+	slots  chan struct{}
+}
+
+// NewDispatcher uses client for deliveries, nil means a client with
+// webhooks.timeout that only connects to public addresses, unless
+// webhooks.allow_private is set.
+func NewDispatcher(store domain.WebhookStore, client *http.Client, cfg config.Webhooks) *Dispatcher {
+	if client == nil {
+		dialer := &net.Dialer{Timeout: cfg.Timeout}
+		if !cfg.AllowPrivate {
+			dialer.Control = publicOnly
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil // the dialer has to see the receiver, not a proxy
+		transport.DialContext = dialer.DialContext
+		client = &http.Client{Timeout: cfg.Timeout, Transport: transport}
+	}
+	return &Dispatcher{
+		store:  store,
+		client: client,
+		cfg:    cfg,
+		queue:  make(chan job, cfg.QueueSize),
+		slots:  make(chan struct{}, cfg.QueueSize),
+	}
+}
+
+// Enqueue schedules event for every webhook of its owner without waiting for
+// the deliveries.
+func (d *Dispatcher) Enqueue(event *domain.AlertEvent) {
+	hooks, err := d.store.Webhooks(event.UserID)
+	if err != nil {
+		slog.Error("loading webhooks failed", "user", event.UserID, "err", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(newPayload(event))
+	if err != nil {
+		slog.Error("encoding webhook payload failed", "event", event.ID, "err", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		j := job{
+			hook: hook,
+			delivery: domain.Delivery{
+				ID:        uuid.NewString(),
+				WebhookID: hook.ID,
+				UserID:    hook.UserID,
+				EventID:   event.ID,
+				URL:       hook.URL,
+			},
+			payload: payload,
+		}
+
+		select {
+		case d.slots <- struct{}{}:
+			d.queue <- j
+		default:
+			d.record(j, domain.DeliveryDeadLetter, 0, errQueueFull)
+		}
+	}
+}
+
+// Run delivers queued events until ctx is cancelled. Deliveries still
+// waiting for a retry at that point are dead-lettered.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case j := <-d.queue:
+					d.record(j, domain.DeliveryDeadLetter, 0, errShutdown)
+					<-d.slots
+				default:
+					return
+				}
+			}
+		case j := <-d.queue:
+			wg.Go(func() {
+				defer func() { <-d.slots }()
+				d.deliver(ctx, j)
+			})
+		}
+	}
+}
+
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.Backoff
+	for range attempt - 1 {
+		delay *= 2
+		if delay >= d.cfg.MaxBackoff {
+			return d.cfg.MaxBackoff
+		}
+	}
+	return delay
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, j job) {
+	for attempt := 1; ; attempt++ {
+		j.delivery.Attempt = attempt
+		code, err := d.post(ctx, &j.hook, j.delivery.ID, j.payload)
+		if err == nil {
+			d.record(j, domain.DeliverySucceeded, code, nil)
+			return
+		}
+
+		if attempt >= d.cfg.MaxAttempts {
+			d.record(j, domain.DeliveryDeadLetter, code, err)
+			return
+		}
+		d.record(j, domain.DeliveryFailed, code, err)
+
+		select {
+		case <-ctx.Done():
+			j.delivery.Attempt++
+			d.record(j, domain.DeliveryDeadLetter, 0, errShutdown)
+			return
+		case <-time.After(d.backoff(attempt)):
+		}
+	}
+}
+
+// post makes a single signed attempt, any 2xx response counts as delivered.
+func (d *Dispatcher) post(ctx context.Context, hook *domain.Webhook, deliveryID string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, alertFired)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) record(j job, status domain.DeliveryStatus, code int, err error) {
+	delivery := j.delivery
+	delivery.Status = status
+	delivery.StatusCode = code
+	delivery.At = time.Now().UTC()
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	if status == domain.DeliveryDeadLetter {
+		delivery.Payload = j.payload
+		slog.Warn("webhook delivery dead-lettered", "webhook", delivery.WebhookID,
+			"event", delivery.EventID, "attempt", delivery.Attempt, "err", err)
+	}
+
+	metrics.ObserveWebhook(string(status))
+	if err := d.store.AddDelivery(&delivery); err != nil {
+		slog.Error("recording webhook delivery failed", "delivery", delivery.ID, "err", err)
+	}
+}
+
+// notifyingAlerts sends every recorded alert event to the webhooks of its
+// owner.
+type notifyingAlerts struct {
+	domain.AlertStore
+	dispatcher *Dispatcher
+}
+
+func Notify(alerts domain.AlertStore, dispatcher *Dispatcher) domain.AlertStore {
+	return &notifyingAlerts{AlertStore: alerts, dispatcher: dispatcher}
+}
+
+func (n *notifyingAlerts) AddEvent(event *domain.AlertEvent) error {
+	if err := n.AlertStore.AddEvent(event); err != nil {
+		return err
+	}
+	n.dispatcher.Enqueue(event)
+	return nil
+}
@@ -0,0 +1,272 @@
+package webhook
+
+import (
+	"context"
+	"cryptoserver/clean/domain"
+	"cryptoserver/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memStore is a WebhookStore recording deliveries, finished is closed once a
+// delivery succeeded or was dead-lettered.
+type memStore struct {
+	mu         sync.Mutex
+	hooks      []domain.Webhook
+	deliveries []domain.Delivery
+	finished   chan struct{}
+}
+
+func newMemStore(hooks ...domain.Webhook) *memStore {
+	return &memStore{hooks: hooks, finished: make(chan struct{})}
+}
+
+func (s *memStore) SaveWebhook(hook *domain.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, *hook)
+	return nil
+}
+
+func (s *memStore) DeleteWebhook(userID, id string) (bool, error) {
+	return false, nil
+}
+
+func (s *memStore) Webhooks(userID string) ([]domain.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hooks, nil
+}
+
+func (s *memStore) AddDelivery(delivery *domain.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = append(s.deliveries, *delivery)
+	if delivery.Status != domain.DeliveryFailed {
+		close(s.finished)
+	}
+	return nil
+}
+
+func (s *memStore) Deliveries(userID string, limit int) ([]domain.Delivery, error) {
+	return nil, nil
+}
+
+func (s *memStore) DeadLetters(userID string, limit int) ([]domain.Delivery, error) {
+	return nil, nil
+}
+
+func (s *memStore) wait(t *testing.T) []domain.Delivery {
+	t.Helper()
+	select {
+	case <-s.finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery did not finish")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deliveries
+}
+
+func testConfig() config.Webhooks {
+	return config.Webhooks{
+		Timeout:     time.Second,
+		MaxAttempts: 3,
+		Backoff:     20 * time.Millisecond,
+		MaxBackoff:  time.Second,
+		QueueSize:   8,
+	}
+}
+
+func testEvent() *domain.AlertEvent {
+	return &domain.AlertEvent{
+		ID:        "event-1",
+		RuleID:    "rule-1",
+		UserID:    "user-1",
+		CoinID:    "bitcoin",
+		Symbol:    "btc",
+		Condition: domain.AlertAbove,
+		Threshold: 50000,
+		Price:     50100,
+		FiredAt:   time.Now().UTC(),
+	}
+}
+
+// dispatch delivers testEvent to a webhook at url and waits for the outcome.
+func dispatch(t *testing.T, url string, client *http.Client, cfg config.Webhooks) []domain.Delivery {
+	t.Helper()
+	store := newMemStore(domain.Webhook{ID: "hook-1", UserID: "user-1", URL: url, Secret: "secret"})
+	d := NewDispatcher(store, client, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	d.Enqueue(testEvent())
+	return store.wait(t)
+}
+
+func statuses(deliveries []domain.Delivery) []domain.DeliveryStatus {
+	result := make([]domain.DeliveryStatus, len(deliveries))
+	for i, delivery := range deliveries {
+		result[i] = delivery.Status
+	}
+	return result
+}
+
+func TestDeliverySigned(t *testing.T) {
+	var signed atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := Sign("secret", r.Header.Get(TimestampHeader), body)
+		signed.Store(r.Header.Get(SignatureHeader) == want && r.Header.Get(EventHeader) == alertFired)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	deliveries := dispatch(t, receiver.URL, receiver.Client(), testConfig())
+	if len(deliveries) != 1 || deliveries[0].Status != domain.DeliverySucceeded {
+		t.Fatalf("deliveries = %v, want one succeeded", statuses(deliveries))
+	}
+	if deliveries[0].StatusCode != http.StatusNoContent {
+		t.Errorf("status code = %d, want %d", deliveries[0].StatusCode, http.StatusNoContent)
+	}
+	if !signed.Load() {
+		t.Errorf("%s does not match the body", SignatureHeader)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int // 503 responses before a 200
+		want      []domain.DeliveryStatus
+		wantCalls int
+	}{
+		{"recovers", 1, []domain.DeliveryStatus{domain.DeliveryFailed, domain.DeliverySucceeded}, 2},
+		{"dead letter", 10, []domain.DeliveryStatus{domain.DeliveryFailed, domain.DeliveryFailed, domain.DeliveryDeadLetter}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls []time.Time
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, time.Now())
+				if len(calls) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer receiver.Close()
+
+			cfg := testConfig()
+			deliveries := dispatch(t, receiver.URL, receiver.Client(), cfg)
+
+			got := statuses(deliveries)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("statuses = %v, want %v", got, tt.want)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", len(calls), tt.wantCalls)
+			}
+			for i := 1; i < len(calls); i++ {
+				if gap := calls[i].Sub(calls[i-1]); gap < cfg.Backoff<<(i-1) {
+					t.Errorf("attempt %d after %v, want at least %v", i+1, gap, cfg.Backoff<<(i-1))
+				}
+			}
+
+			last := deliveries[len(deliveries)-1]
+			if last.Attempt != tt.wantCalls {
+				t.Errorf("last attempt = %d, want %d", last.Attempt, tt.wantCalls)
+			}
+			if last.Status == domain.DeliveryDeadLetter && (last.StatusCode != http.StatusServiceUnavailable || len(last.Payload) == 0) {
+				t.Errorf("dead letter = %+v, want the last status code and the payload", last)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(newMemStore(), nil, config.Webhooks{Backoff: time.Second, MaxBackoff: 5 * time.Second})
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublic(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublic(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultClientRefusesLoopback(t *testing.T) {
+	var called atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called.Store(true)
+	}))
+	defer receiver.Close()
+
+	cfg := testConfig()
+	cfg.MaxAttempts = 1
+	deliveries := dispatch(t, receiver.URL, nil, cfg)
+	if len(deliveries) != 1 || deliveries[0].Status != domain.DeliveryDeadLetter {
+		t.Fatalf("deliveries = %v, want one dead letter", statuses(deliveries))
+	}
+	if !strings.Contains(deliveries[0].Error, errNotPublic.Error()) {
+		t.Errorf("error = %q, want %q", deliveries[0].Error, errNotPublic)
+	}
+	if called.Load() {
+		t.Error("receiver was called")
+	}
+}